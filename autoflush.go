@@ -0,0 +1,44 @@
+package slogxgooglecloudlogging
+
+import (
+	"sync"
+	"time"
+)
+
+// autoFlusher periodically calls a flush function in the background until it is closed.
+type autoFlusher struct {
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// newAutoFlusher creates a new flusher which calls flush at the given interval.
+func newAutoFlusher(interval time.Duration, flush func()) *autoFlusher {
+	f := &autoFlusher{
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go f.run(interval, flush)
+	return f
+}
+
+// close stops the flusher and waits for any in-progress flush to finish.
+func (f *autoFlusher) close() {
+	f.stopOnce.Do(func() { close(f.stop) })
+	<-f.stopped
+}
+
+// run calls flush at the given interval until the flusher is closed.
+func (f *autoFlusher) run(interval time.Duration, flush func()) {
+	defer close(f.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			flush()
+		case <-f.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package slogxgooglecloudlogging
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+// dryRunEntry is the JSON representation of an entry written in dry-run mode.
+type dryRunEntry struct {
+	HTTPRequest    *dryRunHTTPRequest `json:"httpRequest,omitempty"`
+	InsertID       string             `json:"insertId,omitempty"`
+	Labels         map[string]string  `json:"labels,omitempty"`
+	Operation      any                `json:"operation,omitempty"`
+	Payload        any                `json:"payload"`
+	Resource       *dryRunResource    `json:"resource,omitempty"`
+	Severity       string             `json:"severity"`
+	SourceLocation any                `json:"sourceLocation,omitempty"`
+	SpanID         string             `json:"spanId,omitempty"`
+	Timestamp      string             `json:"timestamp"`
+	Trace          string             `json:"trace,omitempty"`
+	TraceSampled   bool               `json:"traceSampled,omitempty"`
+}
+
+// dryRunHTTPRequest is the JSON representation of the HTTP request information of an entry written in dry-run mode.
+type dryRunHTTPRequest struct {
+	CacheFillBytes                 int64  `json:"cacheFillBytes,omitempty"`
+	CacheHit                       bool   `json:"cacheHit,omitempty"`
+	CacheLookup                    bool   `json:"cacheLookup,omitempty"`
+	CacheValidatedWithOriginServer bool   `json:"cacheValidatedWithOriginServer,omitempty"`
+	Latency                        string `json:"latency,omitempty"`
+	LocalIP                        string `json:"localIp,omitempty"`
+	Method                         string `json:"method,omitempty"`
+	Protocol                       string `json:"protocol,omitempty"`
+	Referer                        string `json:"referer,omitempty"`
+	RemoteIP                       string `json:"remoteIp,omitempty"`
+	RequestSize                    int64  `json:"requestSize,omitempty"`
+	ResponseSize                   int64  `json:"responseSize,omitempty"`
+	Status                         int    `json:"status,omitempty"`
+	URL                            string `json:"url,omitempty"`
+	UserAgent                      string `json:"userAgent,omitempty"`
+}
+
+// dryRunResource is the JSON representation of the monitored resource of an entry written in dry-run mode.
+type dryRunResource struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Type   string            `json:"type"`
+}
+
+// dryRunWriter is an entry writer which writes entries to another writer as JSON lines instead of sending them to
+// Google Cloud Logging.
+//
+// Entries which do not have their own resource are written with the writer's resource, if any, just as the client would
+// associate them with its common resource.
+type dryRunWriter struct {
+	lock     sync.Mutex
+	resource *monitoredres.MonitoredResource
+	w        io.Writer
+}
+
+// Flush does nothing since entries are written immediately.
+func (d *dryRunWriter) Flush() error {
+	return nil
+}
+
+// Log writes the entry, ignoring any error.
+func (d *dryRunWriter) Log(e logging.Entry) {
+	_ = d.write(e)
+}
+
+// LogSync writes the entry.
+func (d *dryRunWriter) LogSync(_ context.Context, e logging.Entry) error {
+	return d.write(e)
+}
+
+// write encodes the entry as a single line of JSON and writes it to the underlying writer.
+func (d *dryRunWriter) write(e logging.Entry) error {
+	entry := dryRunEntry{
+		InsertID:     e.InsertID,
+		Labels:       e.Labels,
+		Payload:      e.Payload,
+		Severity:     e.Severity.String(),
+		SpanID:       e.SpanID,
+		Timestamp:    e.Timestamp.Format(time.RFC3339Nano),
+		Trace:        e.Trace,
+		TraceSampled: e.TraceSampled,
+	}
+	if e.Operation != nil {
+		entry.Operation = e.Operation
+	}
+	if e.SourceLocation != nil {
+		entry.SourceLocation = e.SourceLocation
+	}
+	if e.HTTPRequest != nil {
+		entry.HTTPRequest = &dryRunHTTPRequest{
+			CacheFillBytes:                 e.HTTPRequest.CacheFillBytes,
+			CacheHit:                       e.HTTPRequest.CacheHit,
+			CacheLookup:                    e.HTTPRequest.CacheLookup,
+			CacheValidatedWithOriginServer: e.HTTPRequest.CacheValidatedWithOriginServer,
+			LocalIP:                        e.HTTPRequest.LocalIP,
+			RemoteIP:                       e.HTTPRequest.RemoteIP,
+			RequestSize:                    e.HTTPRequest.RequestSize,
+			ResponseSize:                   e.HTTPRequest.ResponseSize,
+			Status:                         e.HTTPRequest.Status,
+		}
+		if e.HTTPRequest.Latency > 0 {
+			entry.HTTPRequest.Latency = e.HTTPRequest.Latency.String()
+		}
+		if req := e.HTTPRequest.Request; req != nil {
+			entry.HTTPRequest.Method = req.Method
+			entry.HTTPRequest.Protocol = req.Proto
+			entry.HTTPRequest.Referer = req.Referer()
+			entry.HTTPRequest.UserAgent = req.UserAgent()
+			if req.URL != nil {
+				entry.HTTPRequest.URL = req.URL.String()
+			}
+		}
+	}
+	resource := e.Resource
+	if resource == nil {
+		resource = d.resource
+	}
+	if resource != nil {
+		entry.Resource = &dryRunResource{
+			Labels: resource.Labels,
+			Type:   resource.Type,
+		}
+	}
+
+	line, err := marshalPayloadValue(entry)
+	if err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, err = d.w.Write(append(line, '\n'))
+	return err
+}
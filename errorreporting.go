@@ -0,0 +1,66 @@
+package slogxgooglecloudlogging
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+// errorReportingEventType is the payload type which identifies an entry as an event for Google Cloud Error Reporting.
+const errorReportingEventType = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+// ServiceContext identifies the service which wrote a log entry.
+type ServiceContext struct {
+	// Service is the name of the service.
+	Service string `json:"service"`
+
+	// Version is the version of the service.
+	Version string `json:"version,omitempty"`
+}
+
+// errorReportingLocation is the location in the source code at which an error was reported.
+type errorReportingLocation struct {
+	FilePath     string `json:"filePath"`
+	FunctionName string `json:"functionName"`
+	LineNumber   int    `json:"lineNumber"`
+}
+
+// applyErrorReporting reshapes the given payload into the format recognized by Google Cloud Error Reporting.
+//
+// The stack trace found under the StackTraceKey option is appended to the message under the MessageKey option and
+// removed from the payload. If there is no stack trace, the location of the log call is reported instead since Error
+// Reporting requires one or the other.
+func (h *GoogleCloudLoggingHandler) applyErrorReporting(obj payloadObject, r slog.Record) (payloadObject, error) {
+	var err error
+	message := r.Message
+	var stack string
+	if raw, ok := obj.get(h.options.StackTraceKey); ok {
+		_ = json.Unmarshal(raw, &stack)
+	}
+	if stack != "" {
+		message += "\n" + stack
+		obj = obj.remove(h.options.StackTraceKey)
+	} else if r.PC != 0 {
+		frame := callerFrame(r.PC)
+		reportContext := map[string]any{
+			"reportLocation": errorReportingLocation{
+				FilePath:     frame.File,
+				FunctionName: frame.Function,
+				LineNumber:   frame.Line,
+			},
+		}
+		if obj, err = obj.set("context", reportContext); err != nil {
+			return nil, err
+		}
+	}
+	if obj, err = obj.set(h.options.MessageKey, message); err != nil {
+		return nil, err
+	}
+	serviceContext := h.options.ServiceContext
+	if serviceContext.Service == "" {
+		serviceContext.Service = h.options.LogName
+	}
+	if obj, err = obj.set("serviceContext", serviceContext); err != nil {
+		return nil, err
+	}
+	return obj.set("@type", errorReportingEventType)
+}
@@ -0,0 +1,66 @@
+package slogxgooglecloudlogging
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrHandlerShutdown is returned by Handle when a record is logged after the handler, or any handler it shares
+	// its lifecycle with, has been shut down.
+	ErrHandlerShutdown = errors.New("handler has been shut down")
+
+	// ErrInvalidCredentials is wrapped by errors returned when Google Cloud Logging rejects the credentials used by
+	// the client.
+	ErrInvalidCredentials = errors.New("GCP credentials are invalid or have expired")
+
+	// ErrMissingCredentials is wrapped by errors returned when the client cannot be created because no credentials
+	// could be found.
+	ErrMissingCredentials = errors.New("GCP credentials not found: set GOOGLE_APPLICATION_CREDENTIALS or run on GCP")
+
+	// ErrPermissionDenied is wrapped by errors returned when the credentials used by the client are not permitted to
+	// write to the log.
+	ErrPermissionDenied = errors.New("GCP credentials do not have permission to write log entries: grant the " +
+		"roles/logging.logWriter role")
+
+	// ErrProjectNotFound is wrapped by errors returned when Google Cloud Logging cannot find the project or log.
+	ErrProjectNotFound = errors.New("GCP project not found: check the ProjectID option")
+
+	// ErrShutdownTimeout is returned by Shutdown when pending records are still being written after the ShutdownTimeout
+	// option has elapsed.
+	ErrShutdownTimeout = errors.New("timed out waiting for pending records to be written")
+
+	// ErrWriteDropped is passed to the OnError option when a record is discarded because the DropOnMaxConcurrentWrites
+	// option is set and the number of in-flight writes has reached MaxConcurrentWrites.
+	ErrWriteDropped = errors.New("record dropped: maximum number of concurrent writes reached")
+)
+
+// classifyError wraps the given error returned by the Google Cloud Logging client with a sentinel error describing
+// common failures so callers can check for them using errors.Is.
+//
+// The original error is still wrapped, so its gRPC status can still be inspected. Errors which are not recognized
+// are returned as-is.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var sentinel error
+	switch status.Code(err) {
+	case codes.Unauthenticated:
+		sentinel = ErrInvalidCredentials
+	case codes.PermissionDenied:
+		sentinel = ErrPermissionDenied
+	case codes.NotFound:
+		sentinel = ErrProjectNotFound
+	default:
+		if !strings.Contains(err.Error(), "could not find default credentials") {
+			return err
+		}
+		sentinel = ErrMissingCredentials
+	}
+	return fmt.Errorf("%w: %w", sentinel, err)
+}
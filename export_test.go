@@ -0,0 +1,95 @@
+package slogxgooglecloudlogging
+
+import (
+	"context"
+	"log/slog"
+
+	"cloud.google.com/go/logging"
+)
+
+// Retry exposes the retry logic of the policy for testing.
+func (p RetryPolicy) Retry(ctx context.Context, fn func(context.Context) error) error {
+	return p.retry(ctx, fn)
+}
+
+// PendingFutures returns the number of async writes still being tracked by the handler.
+func (h *GoogleCloudLoggingHandler) PendingFutures() int {
+	return h.futures.len()
+}
+
+// FuturesClosed determines whether or not Shutdown has stopped the handler from starting any more async writes.
+func (h *GoogleCloudLoggingHandler) FuturesClosed() bool {
+	h.futures.lock.Lock()
+	defer h.futures.lock.Unlock()
+	return h.futures.closed
+}
+
+// ClientRefs returns the number of handlers sharing the client created by the handler.
+func (h *GoogleCloudLoggingHandler) ClientRefs() int32 {
+	if h.lifecycle.clientRefs == nil {
+		return 0
+	}
+	return h.lifecycle.clientRefs.Load()
+}
+
+// RetainClient adds a reference to the handler's client as Clone does, reporting whether or not it succeeded.
+func (h *GoogleCloudLoggingHandler) RetainClient() bool {
+	return h.lifecycle.retain()
+}
+
+// EntryLogger returns the writer the handler uses for the given record.
+func (h *GoogleCloudLoggingHandler) EntryLogger(r slog.Record) EntryWriter {
+	logger, _ := h.entryLogger(r, nil, h.projectFor(r, nil))
+	return logger
+}
+
+// CachedProjects returns the number of projects for which the handler has created clients.
+func (h *GoogleCloudLoggingHandler) CachedProjects() int {
+	if h.projects == nil {
+		return 0
+	}
+	h.projects.lock.RLock()
+	defer h.projects.lock.RUnlock()
+	return len(h.projects.projects)
+}
+
+// CachedLoggers returns the number of loggers cached by the handler.
+func (h *GoogleCloudLoggingHandler) CachedLoggers() int {
+	if h.loggers == nil {
+		return 0
+	}
+	h.loggers.lock.RLock()
+	defer h.loggers.lock.RUnlock()
+	return len(h.loggers.loggers)
+}
+
+// SeverityFor returns the severity the handler maps the given level to.
+func (h *GoogleCloudLoggingHandler) SeverityFor(level slog.Leveler) logging.Severity {
+	return h.severityFor(level)
+}
+
+// SetOTelSpanContext replaces the function used to read the OpenTelemetry span from the context, returning a function
+// which restores the original.
+func SetOTelSpanContext(f func(ctx context.Context) (traceID, spanID string, sampled bool)) func() {
+	original := otelSpanContext
+	otelSpanContext = f
+	return func() { otelSpanContext = original }
+}
+
+// BuildLoggerOptions returns the logger options the handler passes to the client for the given options.
+func BuildLoggerOptions(opts GoogleCloudLoggingHandlerOptions) []logging.LoggerOption {
+	return opts.loggerOptions()
+}
+
+// SetDetectProjectID replaces the function used to detect the project ID, returning a function which restores the
+// original.
+func SetDetectProjectID(f func() (string, error)) func() {
+	original := detectProjectID
+	detectProjectID = f
+	return func() { detectProjectID = original }
+}
+
+// ClassifyError wraps the given client error with a sentinel error describing it, if it is recognized.
+func ClassifyError(err error) error {
+	return classifyError(err)
+}
@@ -0,0 +1,67 @@
+package slogxgooglecloudlogging
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// fallbackWriter writes entries which could not be written to Google Cloud Logging to another writer as JSON lines.
+//
+// The lines follow the structured logging format understood by the Google Cloud logging agents, so entries written
+// to a container's standard output or standard error can still be collected.
+type fallbackWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// write writes the entry along with the reason it could not be written to Google Cloud Logging.
+func (f *fallbackWriter) write(entry logging.Entry, reason error) error {
+	var err error
+	var obj payloadObject
+	ok := false
+	if raw, isRaw := entry.Payload.(json.RawMessage); isRaw {
+		obj, ok = parsePayloadObject(raw)
+	}
+	if !ok {
+		obj = payloadObject{{key: "message"}}
+		if obj[0].value, err = marshalPayloadValue(entry.Payload); err != nil {
+			return err
+		}
+	}
+
+	// set the fields in reverse order since each new field is added to the start of the object
+	if obj, err = obj.set("fallbackReason", reason.Error()); err != nil {
+		return err
+	}
+	if entry.SpanID != "" {
+		if obj, err = obj.set(specialSpanIDKey, entry.SpanID); err != nil {
+			return err
+		}
+	}
+	if entry.Trace != "" {
+		if obj, err = obj.set(specialTraceKey, entry.Trace); err != nil {
+			return err
+		}
+	}
+	if len(entry.Labels) > 0 {
+		if obj, err = obj.set(specialLabelsKey, entry.Labels); err != nil {
+			return err
+		}
+	}
+	if obj, err = obj.set("time", entry.Timestamp.Format(time.RFC3339Nano)); err != nil {
+		return err
+	}
+	if obj, err = obj.set("severity", entry.Severity.String()); err != nil {
+		return err
+	}
+
+	line := append(obj.bytes(), '\n')
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	_, err = f.w.Write(line)
+	return err
+}
@@ -0,0 +1,118 @@
+package slogxgooglecloudlogging
+
+import (
+	"sync"
+
+	"go.innotegrity.dev/async"
+)
+
+// futuresReapInterval is the number of futures added to a handler between scans for completed futures.
+const futuresReapInterval = 100
+
+// trackedFuture wraps an async.Future so the handler can tell whether or not it has completed without blocking.
+type trackedFuture struct {
+	async.Future
+	done chan struct{}
+}
+
+// newTrackedFuture creates a new future which has not yet completed.
+func newTrackedFuture() *trackedFuture {
+	return &trackedFuture{done: make(chan struct{})}
+}
+
+// isDone determines whether or not the future has completed.
+func (f *trackedFuture) isDone() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// countPending returns the number of futures in the given slice which have not yet completed.
+func countPending(futures []*trackedFuture) int {
+	n := 0
+	for _, f := range futures {
+		if f != nil && !f.isDone() {
+			n++
+		}
+	}
+	return n
+}
+
+// reapFutures removes any futures which have already completed from the given slice and returns the result.
+//
+// The slice is compacted in place, so the caller must own the backing array.
+func reapFutures(futures []*trackedFuture) []*trackedFuture {
+	pending := futures[:0]
+	for _, f := range futures {
+		if f != nil && !f.isDone() {
+			pending = append(pending, f)
+		}
+	}
+	for i := len(pending); i < len(futures); i++ {
+		futures[i] = nil
+	}
+	return pending
+}
+
+// futureSet is a collection of pending futures shared by a handler and every handler derived from it.
+//
+// It is safe for concurrent use.
+type futureSet struct {
+	closed  bool
+	futures []*trackedFuture
+	lock    sync.Mutex
+}
+
+// add starts the future using the given function and adds it to the set, periodically removing any futures which
+// have already completed.
+//
+// The future is started while holding the set's lock so that it cannot be missed by a concurrent call to take. If the
+// set has been closed, the function is not called and ErrHandlerShutdown is returned.
+func (s *futureSet) add(f *trackedFuture, start func() async.Future) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrHandlerShutdown
+	}
+	f.Future = start()
+	s.futures = append(s.futures, f)
+	if len(s.futures)%futuresReapInterval == 0 {
+		s.futures = reapFutures(s.futures)
+	}
+	return nil
+}
+
+// close prevents any more futures from being added to the set.
+//
+// Futures already in the set are left in place to be returned by take.
+func (s *futureSet) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+}
+
+// len returns the number of futures in the set.
+func (s *futureSet) len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.futures)
+}
+
+// reap removes any completed futures from the set.
+func (s *futureSet) reap() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.futures = reapFutures(s.futures)
+}
+
+// take removes all of the futures from the set and returns them.
+func (s *futureSet) take() []*trackedFuture {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	futures := s.futures
+	s.futures = []*trackedFuture{}
+	return futures
+}
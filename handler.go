@@ -1,17 +1,83 @@
 package slogxgooglecloudlogging
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"maps"
+	"math/rand"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"cloud.google.com/go/compute/metadata"
 	"cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/apiv2/loggingpb"
 	"go.innotegrity.dev/async"
 	"go.innotegrity.dev/generic"
 	"go.innotegrity.dev/slogx"
 	"go.innotegrity.dev/slogx/formatter"
 	"google.golang.org/api/option"
+	"google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// DefaultCallerFieldKey is the default key of the attribute containing the caller's file and line.
+	DefaultCallerFieldKey = "caller"
+
+	// DefaultCorrelationIDKey is the default key of the attribute containing the ID returned by the
+	// CorrelationIDExtractor.
+	DefaultCorrelationIDKey = "correlation_id"
+
+	// DefaultLabelGroupName is the default name of the group whose attributes are promoted to labels.
+	DefaultLabelGroupName = "__labels__"
+
+	// DefaultMaxPayloadBytes is the default maximum size of a payload, just under the 256KB limit Google Cloud
+	// Logging places on entries.
+	DefaultMaxPayloadBytes = 256000
+
+	// DefaultMessageKey is the default key under which the record's message is placed in the JSON payload.
+	DefaultMessageKey = "message"
+
+	// DefaultNumericSeverityKey is the default key of the field containing the entry's numeric severity when
+	// IncludeNumericSeverity is enabled.
+	DefaultNumericSeverityKey = "severity_number"
+
+	// DefaultStackTraceKey is the default key of the attribute containing a stack trace for Error Reporting.
+	DefaultStackTraceKey = "stack"
+
+	// DefaultTimestampPayloadKey is the default key of the attribute containing the record's time when
+	// IncludeTimestampInPayload is enabled.
+	DefaultTimestampPayloadKey = "time"
+)
+
+// PayloadType is the type of payload sent to Google Cloud Logging.
+type PayloadType int
+
+const (
+	// PayloadTypeJSON sends the formatted record as a JSON payload.
+	PayloadTypeJSON PayloadType = iota
+
+	// PayloadTypeText sends the formatted record as a text payload.
+	PayloadTypeText
+)
+
+var (
+	// logNameRegexp matches log names accepted by Google Cloud Logging.
+	logNameRegexp = regexp.MustCompile(`^[A-Za-z0-9/_\-.]+$`)
+
+	// projectIDRegexp matches GCP project IDs, including legacy domain-scoped project IDs.
+	projectIDRegexp = regexp.MustCompile(`^([a-z0-9][a-z0-9.-]*[a-z0-9]:)?[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
 )
 
 // googleCloudLoggingHandlerOptionsContext can be used to retrieve the options used by the handler from the context.
@@ -19,26 +85,341 @@ type googleCloudLoggingHandlerOptionsContext struct{}
 
 // GoogleCloudLoggingHandlerOptions holds the options for the JSON handler.
 type GoogleCloudLoggingHandlerOptions struct {
+	// AddCallerField will add an attribute containing the file and line from which the record was logged, in the
+	// form "path/to/file.go:123", to the JSON payload.
+	//
+	// This is independent of the IncludeSourceLocation option. No attribute is added if the record does not contain
+	// a program counter.
+	AddCallerField bool
+
+	// AfterFormat is a function used to transform the payload of each entry before it is written, such as to mask
+	// personal information or strip certain keys.
+	//
+	// The function receives the payload once it has been fully processed, including the message, Error Reporting and
+	// key sorting, and before it is checked against MaxPayloadBytes. The function may modify the payload in place and
+	// should not keep it after returning. JSON payloads must remain valid JSON. If the function returns an error, the
+	// record is dropped and the error is returned by Handle, or reported to OnError when writing records
+	// asynchronously.
+	AfterFormat func(ctx context.Context, payload []byte) ([]byte, error)
+
+	// AutoFlushInterval is the interval at which the handler flushes any entries buffered by its loggers in the
+	// background, so entries written using async mode, UseBufferedLogging or BatchSize appear in Google Cloud Logging
+	// promptly without calling Flush().
+	//
+	// Completed async writes are also removed from the handler's pending writes at this interval. The background
+	// flusher is stopped when the handler is shut down. If 0 or negative, entries are only flushed when the underlying
+	// logger decides to or when Flush() or Shutdown() is called.
+	AutoFlushInterval time.Duration
+
+	// BatchFlushInterval is the maximum amount of time entries are held in a partial batch when BatchSize is set.
+	//
+	// It is passed to the Google Cloud Logging client's underlying loggers as their delay threshold and takes
+	// precedence over DelayThreshold. If 0 or less, DelayThreshold is used.
+	BatchFlushInterval time.Duration
+
+	// BatchSize is the number of entries to accumulate before they are written to Google Cloud Logging together.
+	//
+	// Setting it writes entries using the client's internal buffering, as UseBufferedLogging does, with BatchSize
+	// passed to the underlying loggers as their entry count threshold in place of EntryCountThreshold. Batches are
+	// sent in the background, so writes do not wait for them. The RetryPolicy and WriteTimeout options do not apply
+	// to batched entries. If 0 or less, entries are only buffered if UseBufferedLogging is set.
+	//
+	// Batched entries are delivered at most once. The Google Cloud Logging client never resends entries after a
+	// failed write, and it reports the failure to ClientOnError and the next Flush without identifying which entries
+	// failed, so failed entries are neither passed to OnError nor written to the FallbackWriter. Set PartialSuccess
+	// so that an invalid entry does not cause the rest of its batch to be rejected along with it.
+	BatchSize int
+
+	// CallerFieldKey is the key of the attribute added when AddCallerField is enabled.
+	//
+	// By default, the key will be set to "caller" if not supplied.
+	CallerFieldKey string
+
+	// ClientCreationJitter is the maximum random delay to wait before creating the Google Cloud Logging client.
+	//
+	// When many instances start at the same time, such as in serverless environments, spreading out client creation
+	// avoids hitting the rate limits of the Google Cloud Logging API. The wait is cut short if the context passed to
+	// NewGoogleCloudLoggingHandlerWithContext is canceled. If 0, the client is created immediately.
+	ClientCreationJitter time.Duration
+
+	// ClientOnError is a function that is called by the Google Cloud Logging client whenever it fails to write
+	// buffered entries in the background.
+	//
+	// Only entries written using UseBufferedLogging are reported to this function. Synchronous writes, which are the
+	// default, never reach it: their errors are returned by Handle or, when EnableAsync is set, passed to OnError.
+	//
+	// If nil, the client's default behavior of logging the error with the standard log package is used.
+	ClientOnError func(err error)
+
 	// ClientOptions is a list of options for the Google Cloud Logging client.
+	//
+	// The options derived from the Endpoint and WithoutAuthentication options are applied after these options.
 	ClientOptions []option.ClientOption
 
+	// CommonLabels is a set of labels the Google Cloud Logging client's underlying logger attaches to every entry it
+	// writes.
+	//
+	// Unlike Labels, these are applied by the client rather than the handler, so labels set on an entry by the handler
+	// take precedence over them.
+	CommonLabels map[string]string
+
+	// ConcurrentWriteLimit is the number of goroutines the Google Cloud Logging client's underlying logger uses to
+	// send buffered entries when UseBufferedLogging is set.
+	//
+	// If 0 or less, the client's default of 1 is used.
+	ConcurrentWriteLimit int
+
+	// CorrelationIDExtractor is a function used to extract a correlation ID, such as a request or job ID, from the
+	// context in order to tie together the entries written while handling it.
+	//
+	// If the function returns a non-empty ID, it is added to the payload under the CorrelationIDKey and, if the
+	// CorrelationIDLabelKey option is set, attached to the entry as a label. This is independent of trace correlation,
+	// so it can be used by systems which do not use Cloud Trace.
+	CorrelationIDExtractor func(ctx context.Context) string
+
+	// CorrelationIDKey is the key of the attribute added when the CorrelationIDExtractor returns an ID.
+	//
+	// By default, the key will be set to "correlation_id" if not supplied.
+	CorrelationIDKey string
+
+	// CorrelationIDLabelKey is the key of the label to which the ID returned by the CorrelationIDExtractor is
+	// assigned.
+	//
+	// If empty, the ID is only added to the payload.
+	CorrelationIDLabelKey string
+
+	// DebugLogger is a function to which diagnostic messages generated while constructing the handler are sent.
+	//
+	// By default, diagnostic messages are discarded.
+	DebugLogger func(string)
+
+	// DedupeMessage indicates whether or not copies of the record's message should be removed from a JSON payload so
+	// the message only appears once, under the MessageKey at the top level.
+	//
+	// Any field named "msg", "message" or MessageKey whose value is the record's message is removed, including fields
+	// within nested objects, such as the "msg" field added by many formatters. Fields with these names but a different
+	// value are left as-is.
+	DedupeMessage bool
+
+	// DelayThreshold is the maximum amount of time the Google Cloud Logging client's underlying logger buffers
+	// entries before sending them when UseBufferedLogging or BatchSize is set.
+	//
+	// If 0 or less, the client's default of 1 second is used. BatchFlushInterval takes precedence if set.
+	DelayThreshold time.Duration
+
+	// DeriveTraceFromOTel indicates whether or not the trace ID, span ID and sampling decision for the entry should be
+	// taken from the OpenTelemetry span in the context.
+	//
+	// This option requires the package to be built using the "otel" build tag, which pulls in the
+	// go.opentelemetry.io/otel/trace package, otherwise creating the handler fails. The TraceExtractor option takes
+	// precedence over the span if both are set.
+	DeriveTraceFromOTel bool
+
+	// DisableAttrDeduplication indicates whether or not the handler and record attributes should be passed to the
+	// formatter as-is instead of being consolidated.
+	//
+	// When set, attributes with the same key are all passed to the formatter in the order they were added, and
+	// attributes added to the same group by the handler and the record are passed as separate groups with the same
+	// key. With a formatter which writes each attribute as it is given, the payload then contains repeated keys, of
+	// which most JSON parsers, including Google Cloud Logging's, keep only the last value.
+	DisableAttrDeduplication bool
+
+	// DisableHTMLEscape indicates whether or not the HTML characters <, > and & should be left unescaped in JSON
+	// payloads.
+	//
+	// The encoding/json package used by most formatters, including the default one, escapes these characters as
+	// \u003c, \u003e and \u0026, which makes values such as URLs hard to read in Google Cloud Logging.
+	DisableHTMLEscape bool
+
+	// DropOnMaxConcurrentWrites will discard records instead of blocking when async is enabled and the number of
+	// in-flight writes has reached MaxConcurrentWrites.
+	//
+	// Each discarded record is passed to the OnError function along with ErrWriteDropped.
+	DropOnMaxConcurrentWrites bool
+
+	// DryRun indicates whether or not entries should be written to the DryRunWriter as JSON lines instead of being
+	// sent to Google Cloud Logging.
+	//
+	// Records are still filtered, formatted and turned into entries exactly as they would otherwise be, which is
+	// useful for checking what would be sent during local development. No client is created, so no credentials are
+	// required and Shutdown has nothing to close.
+	DryRun bool
+
+	// DryRunWriter is the writer to which entries are written when DryRun is enabled.
+	//
+	// By default, entries will be written to os.Stdout if not supplied.
+	DryRunWriter io.Writer
+
 	// EnableAsync will execute the Handle() function in a separate goroutine.
 	//
 	// When async is enabled, you should be sure to call the Shutdown() function or use the slogx.Shutdown()
 	// function to ensure all goroutines are finished and any pending records have been written.
 	EnableAsync bool
 
+	// EnableErrorReporting indicates whether or not entries with a severity of logging.Error or higher should be
+	// formatted so they are picked up by Google Cloud Error Reporting.
+	//
+	// The payload is given the ReportedErrorEvent type along with a serviceContext built from the ServiceContext
+	// option, and any stack trace found under the StackTraceKey is moved to the end of the message under the
+	// MessageKey.
+	EnableErrorReporting bool
+
+	// Endpoint overrides the address of the Google Cloud Logging API used by the client, such as "localhost:8085" for
+	// a local emulator or fake server.
+	//
+	// Combined with WithoutAuthentication, this makes testing against a local emulator turnkey.
+	Endpoint string
+
+	// EntryByteThreshold is the total size in bytes of the entries the Google Cloud Logging client's underlying logger
+	// buffers before sending them when UseBufferedLogging or BatchSize is set.
+	//
+	// If 0 or less, the client's default of 8 MiB is used.
+	EntryByteThreshold int
+
+	// EntryCountThreshold is the number of entries the Google Cloud Logging client's underlying logger buffers before
+	// sending them when UseBufferedLogging is set.
+	//
+	// If 0 or less, the client's default of 1000 entries is used. It is ignored if BatchSize is set.
+	EntryCountThreshold int
+
+	// ErrorAttrKey is the key of the attribute watched for errors when PromoteOnErrorAttr is enabled.
+	//
+	// If empty, attributes with the key "err" or "error" are watched.
+	ErrorAttrKey string
+
+	// FallbackToTextPayload will send the record's message as a text payload if the RecordFormatter does not produce
+	// a valid JSON payload.
+	//
+	// If false, records whose formatted payload is empty or invalid JSON are discarded and an error is returned.
+	FallbackToTextPayload bool
+
+	// FallbackWriter is a writer to which entries are written as JSON lines when they cannot be written to Google
+	// Cloud Logging, such as os.Stderr.
+	//
+	// Each line uses the structured logging format understood by the Google Cloud logging agents and includes the
+	// reason the write failed under the "fallbackReason" key. Entries are only written to the fallback once any
+	// retries have been exhausted. Entries written using UseBufferedLogging or in batches using BatchSize are never
+	// written to it since their failures are not reported for individual entries. If nil, entries which cannot be
+	// written are dropped.
+	FallbackWriter io.Writer
+
+	// FunctionNameLabelKey is the key of the label containing the fully-qualified name of the function from which the
+	// record was logged, such as "github.com/org/repo/pkg.(*Client).RetryLoop".
+	//
+	// This is independent of the IncludeSourceLocation option. If empty, or if the record does not contain a program
+	// counter or the function name cannot be resolved from it, the label is not set.
+	FunctionNameLabelKey string
+
+	// GroupPathLabelKey is the key of the label containing the dot-separated path of groups added to the handler
+	// using WithGroup.
+	//
+	// If empty or if no groups have been added to the handler, the label is not set.
+	GroupPathLabelKey string
+
+	// HTTPRequestExtractor is a function used to extract the HTTP request information to attach to the Google Cloud
+	// Logging entry.
+	//
+	// The attributes passed to the function are the consolidated handler and record attributes. If the function
+	// returns nil, no HTTP request information is attached to the entry.
+	HTTPRequestExtractor func(ctx context.Context, attrs []slog.Attr) *logging.HTTPRequest
+
+	// IncludeNumericSeverity indicates whether or not the entry's severity should be added to JSON payloads as its
+	// numeric logging.Severity value, such as 500 for logging.Error.
+	//
+	// Google Cloud Logging stores the entry's severity separately from its payload, so this keeps a severity which
+	// can be compared using ranges available when entries are exported to sinks such as BigQuery. The value is added
+	// after any escalation of the severity. Text payloads are left unchanged.
+	IncludeNumericSeverity bool
+
+	// IncludeSourceLocation will attach the file, line and function from which the record was logged to the Google
+	// Cloud Logging entry.
+	//
+	// No source location is attached if the record does not contain a program counter.
+	IncludeSourceLocation bool
+
+	// IncludeTimestampInPayload indicates whether or not the record's time should be added to the payload as an
+	// RFC 3339 timestamp with nanosecond precision.
+	//
+	// Google Cloud Logging stores the entry's timestamp separately from its payload, so this keeps the timestamp
+	// available when entries are exported to sinks which only preserve the payload. The attribute is added after
+	// the TimestampLocation and TimestampPrecision options have been applied. No attribute is added if the record's
+	// time is zero.
+	IncludeTimestampInPayload bool
+
+	// InsertIDFunc is a function used to generate the insert ID for the Google Cloud Logging entry.
+	//
+	// Entries which share the same insert ID are deduplicated by Google Cloud Logging. If the function returns an
+	// empty string, the insert ID is left unset and one is generated by Google Cloud Logging instead.
+	InsertIDFunc func(r slog.Record, attrs []slog.Attr) string
+
+	// LabelAttrPrefix is the key prefix which identifies top-level attributes that should be promoted to labels.
+	//
+	// Matching attributes are removed from the payload and their values are added to the entry's labels using the
+	// remainder of the key, so an attribute of "label.region" becomes a "region" label when the prefix is "label.".
+	// Promoted labels take precedence over the static Labels but not over those returned by the LabelExtractor. If
+	// empty, no attributes are promoted.
+	LabelAttrPrefix string
+
+	// LabelExtractor is a function used to extract labels to attach to the Google Cloud Logging entry.
+	//
+	// The attributes passed to the function are the consolidated handler and record attributes. If the function
+	// returns a nil or empty map, no labels are set on the entry.
+	LabelExtractor func(ctx context.Context, r slog.Record, attrs []slog.Attr) map[string]string
+
+	// LabelGroupName is the name of the top-level group whose attributes should be promoted to labels.
+	//
+	// The group is removed from the payload and each of its attributes is added to the entry's labels using the
+	// attribute's key and its value as a string, so logger.WithGroup("__labels__").Info("msg", "region", "us") adds
+	// a "region" label. The keys of groups nested within the group are joined using ".". The group's labels take
+	// precedence over the static Labels and those added to the context using WithLabels, but not over those returned
+	// by the LabelExtractor.
+	//
+	// By default, the name will be set to "__labels__" if not supplied.
+	LabelGroupName string
+
+	// Labels is a set of labels to attach to every Google Cloud Logging entry written by the handler.
+	//
+	// The map is copied when the handler is created, so later changes to it have no effect on the handler. Labels
+	// added to the context using WithLabels, promoted using LabelAttrPrefix or LabelGroupName or returned by the
+	// LabelExtractor take precedence over these labels.
+	Labels map[string]string
+
+	// LazyInit defers creating the Google Cloud Logging client until the first entry is written, so creating the
+	// handler does not depend on the network being available.
+	//
+	// The client is only created once. If creating it fails, the error is returned by Handle, or reported to OnError
+	// when writing records asynchronously, for every record written afterwards; entries which are buffered using
+	// UseBufferedLogging or BatchSize report the error to ClientOnError instead. Any ClientCreationJitter is waited
+	// for by the first write. The project ID is still detected when the handler is created if the ProjectID option
+	// is empty.
+	LazyInit bool
+
 	// Level is the minimum log level to write to the handler.
 	//
+	// If a *slog.LevelVar is supplied, changes to its level take effect immediately for the handler and any handlers
+	// derived from it using WithAttrs() or WithGroup().
+	//
 	// By default, the level will be set to slog.LevelInfo if not supplied.
 	Level slog.Leveler
 
 	// LevelMapper is a function to use to map an slog.Leveler level to the corresponding Google Cloud Logging severity.
 	//
-	// If nil, the default mapper will be used, which should be fine for most cases.
+	// If nil, the default mapper will be used, which should be fine for most cases. Use StrictLevelMapper to catch
+	// levels which do not match a named slogx level.
 	LevelMapper func(slog.Leveler) logging.Severity
 
+	// LevelSeverityOverrides overrides the Google Cloud Logging severity of specific levels.
+	//
+	// Levels found in the map use the given severity, while all other levels are mapped using the LevelMapper or the
+	// default mapper. The map is copied when the handler is created, so later changes to it have no effect on the
+	// handler.
+	LevelSeverityOverrides map[slogx.Level]logging.Severity
+
 	// LoggerOptions is a list of options to pass to the Google Cloud Logging client's underlying logger.
+	//
+	// The CommonLabels, ConcurrentWriteLimit, DelayThreshold, EntryByteThreshold, EntryCountThreshold and
+	// PartialSuccess options are applied after these options, so they take precedence over the equivalent logger
+	// options.
 	LoggerOptions []logging.LoggerOption
 
 	// LogName is the name of the log to use when logging messages.
@@ -46,32 +427,302 @@ type GoogleCloudLoggingHandlerOptions struct {
 	// This option is required.
 	LogName string
 
+	// LogNameFunc is a function used to choose the log to which the entry for a record is written.
+	//
+	// If the function returns a name other than LogName, the entry is written using a logger for that log created
+	// from the handler's client. Loggers are cached by name so they are only created once. If the function returns an
+	// empty string or the handler does not have a client, the entry is written to the default log.
+	//
+	// The name is a log ID rather than a fully-qualified "projects/PROJECT_ID/logs/LOG_ID" name, and the log always
+	// belongs to the project, folder, organization or billing account the client was created for. The Google Cloud
+	// Logging client rejects entries whose LogName field is set, so it is not possible to set the fully-qualified log
+	// name of individual entries; use a separate handler created with a client for the other parent instead.
+	LogNameFunc func(r slog.Record, attrs []slog.Attr) string
+
+	// MaxConcurrentWrites is the maximum number of async writes that may be in-flight at any one time.
+	//
+	// Once the limit is reached, Handle() blocks until a write completes or the record's context is done, unless
+	// DropOnMaxConcurrentWrites is set. A value of 0 or less means there is no limit.
+	MaxConcurrentWrites int
+
+	// MaxPayloadBytes is the maximum size of the payload of an entry, since Google Cloud Logging rejects entries
+	// larger than 256KB.
+	//
+	// Larger payloads are truncated before they are written and the truncation is reported to OnError. The largest
+	// string fields of JSON payloads, including those within groups, are shortened first and a "truncated" field is
+	// added to the payload. If that is not enough, such as when most of the payload is made up of arrays or numbers,
+	// every field other than the shortened message is discarded.
+	//
+	// By default, the maximum will be set to DefaultMaxPayloadBytes if not supplied. If negative, payloads are not
+	// limited.
+	MaxPayloadBytes int
+
+	// MessageKey is the key under which the record's message is placed in the JSON payload.
+	//
+	// Google Cloud Logging uses this field as the summary line for the entry in the Logs Explorer. The message is
+	// always written to this key, regardless of the output produced by the RecordFormatter.
+	//
+	// By default, the key will be set to "message" if not supplied.
+	MessageKey string
+
+	// MinSeverity is the minimum Google Cloud Logging severity of entries to write.
+	//
+	// Records which pass the Level filter but whose mapped severity is below this value are discarded. This allows a
+	// single logger to send verbose logs to other handlers while only sending more severe entries to Google Cloud
+	// Logging. Severities raised using WithMinSeverity are compared against this value. By default, entries of any
+	// severity are written.
+	MinSeverity logging.Severity
+
+	// MonitoredResource is the monitored resource to associate with all entries written by the handler.
+	//
+	// If nil, the resource is automatically detected by the Google Cloud Logging client, falling back to the global
+	// resource for the project when it cannot be detected. The ResourceExtractor option can be used to override the
+	// resource for individual entries.
+	MonitoredResource *monitoredres.MonitoredResource
+
+	// NumericSeverityKey is the key of the field added when IncludeNumericSeverity is enabled.
+	//
+	// By default, the key will be set to "severity_number" if not supplied.
+	NumericSeverityKey string
+
+	// OnError is a function that is called whenever an async write fails, an async record is dropped because of the
+	// DropOnMaxConcurrentWrites option or a panic, such as one raised by the RecordFormatter, is recovered while
+	// handling a record.
+	//
+	// If nil, errors encountered while writing records asynchronously are silently discarded. Recovered panics are
+	// still returned by Handle when writing records synchronously.
+	OnError func(err error, r slog.Record)
+
+	// OnWriteFailure is a function that is called after each failed write to Google Cloud Logging.
+	//
+	// The function is passed the error, the amount of time spent on the write, including any retries, and the
+	// severity of the entry.
+	OnWriteFailure func(err error, latency time.Duration, severity logging.Severity)
+
+	// OnWriteSuccess is a function that is called after each successful write to Google Cloud Logging.
+	//
+	// The function is passed the size of the payload in bytes, the amount of time spent on the write, including any
+	// retries, and the severity of the entry. When UseBufferedLogging or BatchSize is set, the latency only includes
+	// the time taken to buffer the entry.
+	OnWriteSuccess func(bytes int, latency time.Duration, severity logging.Severity)
+
+	// OperationExtractor is a function used to extract information about the long-running operation with which the
+	// Google Cloud Logging entry is associated from the context.
+	//
+	// If the function returns nil, no operation is attached to the entry.
+	OperationExtractor func(ctx context.Context) *loggingpb.LogEntryOperation
+
+	// PartialSuccess allows the valid entries in a write to Google Cloud Logging to be stored even if some of the
+	// entries are invalid.
+	//
+	// By default, a single invalid entry causes every entry in the same write to be rejected. This matters most when
+	// BatchSize or UseBufferedLogging is set, since buffered entries are written together.
+	PartialSuccess bool
+
+	// PayloadType is the type of payload to send to Google Cloud Logging.
+	//
+	// When PayloadTypeText is used, the output of the RecordFormatter is sent as-is in the entry's text payload, so
+	// a formatter which produces a human-readable line should be supplied. By default, PayloadTypeJSON is used.
+	PayloadType PayloadType
+
+	// PreserveZeroTime leaves the timestamp of entries for records with a zero time unset rather than using the time
+	// at which the record is handled.
+	//
+	// Entries without a timestamp are stamped by the Google Cloud Logging client when they are sent, while writers
+	// supplied using NewGoogleCloudLoggingHandlerWithWriter, the DryRunWriter and the FallbackWriter receive the zero
+	// time as-is.
+	PreserveZeroTime bool
+
 	// ProjectID is the ID of the GCP project to which the logger belongs.
 	//
-	// This option is required.
+	// If empty, NewGoogleCloudLoggingHandler and NewGoogleCloudLoggingHandlerWithContext attempt to detect the project
+	// ID using the GCP metadata server and fail if it cannot be detected, while Clone uses the existing handler's
+	// project ID. This option is required by all other constructors.
 	ProjectID string
 
+	// ProjectRouter is a function used to choose the project to which the entry for a record is written, such as a
+	// per-customer project.
+	//
+	// If the function returns a project ID other than ProjectID, the entry is written using a client created for that
+	// project with the same ClientOptions and LoggerOptions. Clients are created the first time a project is chosen
+	// and cached so they are only created once; if a client cannot be created, the error is returned and creating it
+	// is attempted again for the next entry. Every cached client is closed when the handler is shut down. If the
+	// function returns an empty string, the entry is written to the ProjectID project.
+	//
+	// Like the client options described by NewGoogleCloudLoggingHandlerWithContext, this option requires a handler
+	// which created its own client or was cloned from one. It is also ignored in dry run mode.
+	ProjectRouter func(r slog.Record, attrs []slog.Attr) string
+
+	// PromoteOnErrorAttr will increase the severity of the entry to logging.Error if the record contains a non-nil
+	// error attribute at the top level.
+	//
+	// Entries whose severity is already logging.Error or higher are not changed. Use ErrorAttrKey to control which
+	// attribute is watched.
+	PromoteOnErrorAttr bool
+
 	// RecordFormatter specifies the formatter to use to format the record before sending it to the GCP logger.
 	//
 	// You should always be sure to format the buffer into a proper JSON payload.
 	//
-	// If no formatter is supplied, formatter.DefaultJSONFormatter is used to format the output.
+	// If no formatter is supplied, formatter.DefaultJSONFormatter is used to format the output, which encodes groups
+	// as nested JSON objects rather than flattening their keys so they can be expanded in the Logs Explorer. The
+	// handler's options are only added to the context passed to the formatter if it implements OptionsFormatter.
 	RecordFormatter formatter.BufferFormatter
+
+	// RedactFunc is a function used to redact the values of sensitive attributes before the record is formatted.
+	//
+	// The function is called for every attribute other than groups and those redacted using RedactKeys, with the key
+	// being the attribute's full path of groups joined using ".". It returns the value to use for the attribute and
+	// whether or not to keep the attribute; attributes which are not kept are dropped from the payload.
+	RedactFunc func(key string, value slog.Value) (slog.Value, bool)
+
+	// RedactKeys is a list of attribute keys whose values are replaced with RedactedValue before the record is
+	// formatted.
+	//
+	// Attributes within groups are matched by their full path of groups joined using ".", so a key of "user.ssn"
+	// matches the "ssn" attribute within the "user" group, while a key of "user" redacts the whole group. The list is
+	// copied when the handler is created, so later changes to it have no effect on the handler.
+	RedactKeys []string
+
+	// ResourceExtractor is a function used to determine the monitored resource to associate with each Google Cloud
+	// Logging entry, such as the pod a record was collected from when aggregating the logs of several pods.
+	//
+	// If the function is nil or returns nil, the entry is associated with the MonitoredResource or the automatically
+	// detected resource.
+	ResourceExtractor func(ctx context.Context, r slog.Record) *monitoredres.MonitoredResource
+
+	// RetryPolicy is the policy to use when retrying writes which fail due to a transient error.
+	//
+	// If nil, failed writes are not retried. Retries are only performed for synchronous writes since buffered
+	// entries are retried by the Google Cloud Logging client itself.
+	RetryPolicy *RetryPolicy
+
+	// Sampler is a function used to decide whether or not a record which passes the level check should be written.
+	//
+	// If the function returns false, the record is dropped without being written. This allows, for example, only 1 in
+	// N informational records to be written while always writing warnings and errors. If nil, every record is written.
+	Sampler func(r slog.Record) bool
+
+	// ServiceContext identifies the service which writes the entries.
+	//
+	// If set, it is added to the JSON payload of every entry under the "serviceContext" key. Entries formatted for
+	// Google Cloud Error Reporting always include a service context, using the LogName as the service name if it is
+	// empty.
+	ServiceContext ServiceContext
+
+	// SeverityFromPayloadKey is the key of a top-level string field in the formatted JSON payload from which the
+	// severity of the entry is taken, such as "severity" or "level".
+	//
+	// If the field is present and names a known severity, as parsed by logging.ParseSeverity, it is used instead of
+	// the severity determined from the record's level. The MinSeverity option is applied to the resulting severity
+	// after the record has been formatted. If empty, the severity is always determined from the record's level.
+	SeverityFromPayloadKey string
+
+	// ShutdownTimeout bounds the total amount of time Shutdown will wait for pending records to be written.
+	//
+	// Once it elapses, Shutdown stops waiting, closes the client and returns an error wrapping ErrShutdownTimeout. A
+	// value of 0 waits indefinitely.
+	ShutdownTimeout time.Duration
+
+	// SkipOnCanceledContext indicates whether or not asynchronous writes should be skipped if the context passed to
+	// Handle has been canceled or has expired by the time the write starts.
+	//
+	// Skipped writes are reported to OnError with the context's error. This option is ignored unless EnableAsync is
+	// set.
+	SkipOnCanceledContext bool
+
+	// SortKeys indicates whether or not the fields of a JSON payload should be sorted by key, including the fields of
+	// any nested objects such as groups.
+	//
+	// This gives payloads a stable field order regardless of the order produced by the RecordFormatter, which makes
+	// entries easier to compare and scan.
+	SortKeys bool
+
+	// StackTraceKey is the key of the top-level attribute containing the stack trace to report to Google Cloud Error
+	// Reporting when EnableErrorReporting is set.
+	//
+	// If empty, DefaultStackTraceKey is used.
+	StackTraceKey string
+
+	// TimestampLocation is the location to which the record's time is converted before it is formatted and attached
+	// to the Google Cloud Logging entry.
+	//
+	// A common value is time.UTC in order to normalize all timestamps. If nil, the time is left as-is.
+	TimestampLocation *time.Location
+
+	// TimestampPayloadKey is the key of the attribute added when IncludeTimestampInPayload is enabled.
+	//
+	// By default, the key will be set to "time" if not supplied.
+	TimestampPayloadKey string
+
+	// TimestampPrecision is the precision to which the record's time is truncated before it is formatted and attached
+	// to the Google Cloud Logging entry, including the payload attribute added by IncludeTimestampInPayload.
+	//
+	// For example, time.Millisecond drops any sub-millisecond precision for downstream systems which cannot handle
+	// nanosecond timestamps. If 0 or negative, the time is not truncated.
+	TimestampPrecision time.Duration
+
+	// TraceExtractor is a function used to extract the Cloud Trace trace ID, span ID and sampling decision for the
+	// entry from the context.
+	//
+	// The trace ID is automatically formatted as "projects/PROJECT_ID/traces/TRACE_ID" before being attached to the
+	// entry, using the project chosen by the ProjectRouter option if any, unless it is already a full resource name.
+	// The returned trace takes precedence over one derived using DeriveTraceFromOTel. If the function returns an empty
+	// trace ID, the span ID and sampling decision are ignored and the trace fields are left unset or taken from the
+	// special "logging.googleapis.com/trace" and "logging.googleapis.com/spanId" attributes if present.
+	//
+	// TraceFromHeader and TraceFromTraceparent can be used to parse an X-Cloud-Trace-Context or W3C traceparent
+	// header value stored in the context by HTTP middleware.
+	TraceExtractor func(ctx context.Context) (traceID, spanID string, sampled bool)
+
+	// UseBufferedLogging will write entries using the Google Cloud Logging client's internal buffering rather than
+	// sending each entry synchronously.
+	//
+	// Buffered entries are sent in the background by the client and are flushed when Flush() or Shutdown() is
+	// called. Because entries are sent in the background, errors writing them are not returned by Handle().
+	UseBufferedLogging bool
+
+	// UseGlobalResource will associate entries with the global monitored resource for the project rather than the
+	// resource detected by the Google Cloud Logging client.
+	//
+	// This is useful when running outside of GCP, such as during local development or in CI, where the detected
+	// resource is meaningless. This option is ignored if MonitoredResource is supplied. It only changes the resource
+	// entries are associated with: the client still probes the metadata server once per process to detect a resource
+	// when the first logger is created.
+	UseGlobalResource bool
+
+	// WithoutAuthentication indicates whether or not the client should connect without any credentials.
+	//
+	// If Endpoint is also set, the connection to the endpoint is made without TLS as expected by local emulators.
+	WithoutAuthentication bool
+
+	// WriteTimeout is the maximum amount of time to wait for each synchronous write to Google Cloud Logging to
+	// complete.
+	//
+	// If a write does not complete before the timeout, it fails with a deadline exceeded error. A value of 0 means
+	// there is no timeout.
+	WriteTimeout time.Duration
 }
 
 // DefaultGoogleCloudLoggingHandlerOptions returns a default set of options for the handler.
 func DefaultGoogleCloudLoggingHandlerOptions() GoogleCloudLoggingHandlerOptions {
 	return GoogleCloudLoggingHandlerOptions{
-		ClientOptions:   []option.ClientOption{},
-		Level:           slog.LevelInfo,
-		LoggerOptions:   []logging.LoggerOption{},
-		RecordFormatter: formatter.DefaultJSONFormatter(),
+		CallerFieldKey:      DefaultCallerFieldKey,
+		ClientOptions:       []option.ClientOption{},
+		Level:               slog.LevelInfo,
+		LoggerOptions:       []logging.LoggerOption{},
+		MaxPayloadBytes:     DefaultMaxPayloadBytes,
+		MessageKey:          DefaultMessageKey,
+		RecordFormatter:     formatter.DefaultJSONFormatter(),
+		StackTraceKey:       DefaultStackTraceKey,
+		TimestampPayloadKey: DefaultTimestampPayloadKey,
 	}
 }
 
 // GetGoogleCloudLoggingHandlerOptionsFromContext retrieves the options from the context.
 //
-// If the options are not set in the context, a set of default options is returned instead.
+// The handler only adds its options to the context passed to formatters which implement OptionsFormatter. If the
+// options are not set in the context, a set of default options is returned instead.
 func GetGoogleCloudLoggingHandlerOptionsFromContext(ctx context.Context) *GoogleCloudLoggingHandlerOptions {
 	o := ctx.Value(googleCloudLoggingHandlerOptionsContext{})
 	if o != nil {
@@ -88,168 +739,1280 @@ func (o *GoogleCloudLoggingHandlerOptions) AddToContext(ctx context.Context) con
 	return context.WithValue(ctx, googleCloudLoggingHandlerOptionsContext{}, o)
 }
 
-// DefaultGoogleCloudLoggingHandlerLevelMapper is a default function for mapping slog levels to GCP logging levels.
-func DefaultGoogleCloudLoggingHandlerLevelMapper(level slog.Leveler) logging.Severity {
-	switch slogx.Level(level.Level()) {
-	case slogx.LevelTrace, slogx.LevelDebug:
-		return logging.Debug
-	case slogx.LevelInfo:
-		return logging.Info
-	case slogx.LevelNotice:
-		return logging.Notice
-	case slogx.LevelWarn:
-		return logging.Warning
-	case slogx.LevelError:
-		return logging.Error
-	case slogx.LevelFatal:
-		return logging.Critical
-	case slogx.LevelPanic:
-		return logging.Emergency
+// clientOptions returns the options to use when creating the Google Cloud Logging client.
+func (o *GoogleCloudLoggingHandlerOptions) clientOptions() []option.ClientOption {
+	clientOpts := slices.Clip(o.ClientOptions)
+	if o.Endpoint != "" {
+		clientOpts = append(clientOpts, option.WithEndpoint(o.Endpoint))
 	}
-	return logging.Default
-}
-
-// googleCloudLoggingHandler is a log handler that writes records to Google Cloud Logging.
-type googleCloudLoggingHandler struct {
-	activeGroup string
-	attrs       []slog.Attr
-	client      *logging.Client
-	futures     []async.Future
-	groups      []string
-	logger      *logging.Logger
-	options     GoogleCloudLoggingHandlerOptions
+	if o.WithoutAuthentication {
+		clientOpts = append(clientOpts, option.WithoutAuthentication())
+		if o.Endpoint != "" {
+			clientOpts = append(clientOpts,
+				option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+		}
+	}
+	return clientOpts
 }
 
-// NewGoogleCloudLoggingHandler creates a new handler object.
-func NewGoogleCloudLoggingHandler(opts GoogleCloudLoggingHandlerOptions) (*googleCloudLoggingHandler, error) {
-	// validate required options
-	if opts.LogName == "" {
-		return nil, errors.New("log name is required and cannot be empty")
+// loggerOptions returns the options to use when creating the Google Cloud Logging client's underlying logger.
+func (o *GoogleCloudLoggingHandlerOptions) loggerOptions() []logging.LoggerOption {
+	loggerOpts := append([]logging.LoggerOption{}, o.LoggerOptions...)
+	if o.MonitoredResource != nil {
+		loggerOpts = append(loggerOpts, logging.CommonResource(o.MonitoredResource))
+	} else if o.UseGlobalResource {
+		loggerOpts = append(loggerOpts, logging.CommonResource(&monitoredres.MonitoredResource{
+			Type: "global",
+			Labels: map[string]string{
+				"project_id": o.ProjectID,
+			},
+		}))
 	}
-	if opts.ProjectID == "" {
-		return nil, errors.New("project ID is required and cannot be empty")
+	if o.CommonLabels != nil {
+		loggerOpts = append(loggerOpts, logging.CommonLabels(maps.Clone(o.CommonLabels)))
 	}
-
-	// set default options
-	if opts.Level == nil {
-		opts.Level = slog.LevelInfo
+	if o.ConcurrentWriteLimit > 0 {
+		loggerOpts = append(loggerOpts, logging.ConcurrentWriteLimit(o.ConcurrentWriteLimit))
 	}
-
-	// create the handler
-	client, err := logging.NewClient(context.Background(), opts.ProjectID, opts.ClientOptions...)
-	if err != nil {
-		return nil, err
+	delayThreshold, entryCountThreshold := o.DelayThreshold, o.EntryCountThreshold
+	if o.BatchFlushInterval > 0 {
+		delayThreshold = o.BatchFlushInterval
 	}
-	return &googleCloudLoggingHandler{
-		attrs:   []slog.Attr{},
-		client:  client,
-		logger:  client.Logger(opts.LogName, opts.LoggerOptions...),
-		futures: []async.Future{},
-		groups:  []string{},
-		options: opts,
-	}, nil
+	if o.BatchSize > 0 {
+		entryCountThreshold = o.BatchSize
+	}
+	if delayThreshold > 0 {
+		loggerOpts = append(loggerOpts, logging.DelayThreshold(delayThreshold))
+	}
+	if o.EntryByteThreshold > 0 {
+		loggerOpts = append(loggerOpts, logging.EntryByteThreshold(o.EntryByteThreshold))
+	}
+	if entryCountThreshold > 0 {
+		loggerOpts = append(loggerOpts, logging.EntryCountThreshold(entryCountThreshold))
+	}
+	if o.PartialSuccess {
+		loggerOpts = append(loggerOpts, logging.PartialSuccess())
+	}
+	return loggerOpts
 }
 
-// Enabled determines whether or not the given level is enabled in this handler.
-func (h googleCloudLoggingHandler) Enabled(ctx context.Context, level slog.Level) bool {
-	return level >= h.options.Level.Level()
+// buffered determines whether or not entries are written using the Google Cloud Logging client's internal buffering.
+func (o *GoogleCloudLoggingHandlerOptions) buffered() bool {
+	return o.UseBufferedLogging || o.BatchSize > 0
 }
 
-// Handle actually handles posting the record to the HTTP listener.
-//
-// Any attributes duplicated between the handler and record, including within groups, are automaticlaly removed.
-// If a duplicate is encountered, the last value found will be used for the attribute's value.
-func (h *googleCloudLoggingHandler) Handle(ctx context.Context, r slog.Record) error {
-	handlerCtx := h.options.AddToContext(ctx)
-	if !h.options.EnableAsync {
-		return h.handle(handlerCtx, r)
+// setDefaults sets the default value for any options which have not been supplied.
+func (o *GoogleCloudLoggingHandlerOptions) setDefaults() {
+	if o.Level == nil {
+		o.Level = slog.LevelInfo
+	}
+	if o.CallerFieldKey == "" {
+		o.CallerFieldKey = DefaultCallerFieldKey
+	}
+	if o.CorrelationIDKey == "" {
+		o.CorrelationIDKey = DefaultCorrelationIDKey
+	}
+	if o.DebugLogger == nil {
+		o.DebugLogger = func(string) {}
+	}
+	if o.DryRun && o.DryRunWriter == nil {
+		o.DryRunWriter = os.Stdout
+	}
+	if o.LabelGroupName == "" {
+		o.LabelGroupName = DefaultLabelGroupName
+	}
+	if o.MaxPayloadBytes == 0 {
+		o.MaxPayloadBytes = DefaultMaxPayloadBytes
+	}
+	if o.MessageKey == "" {
+		o.MessageKey = DefaultMessageKey
+	}
+	if o.NumericSeverityKey == "" {
+		o.NumericSeverityKey = DefaultNumericSeverityKey
+	}
+	if o.StackTraceKey == "" {
+		o.StackTraceKey = DefaultStackTraceKey
+	}
+	if o.TimestampPayloadKey == "" {
+		o.TimestampPayloadKey = DefaultTimestampPayloadKey
 	}
-
-	future := async.Exec(func() any {
-		return h.handle(handlerCtx, r)
-	})
-	h.futures = append(h.futures, future)
-	return nil
 }
 
-// Shutdown is responsible for cleaning up resources used by the handler.
-func (h googleCloudLoggingHandler) Shutdown(continueOnError bool) error {
-	for _, f := range h.futures {
-		if f != nil {
-			f.Await()
-		}
+// validate ensures that all required options have been supplied and are valid.
+func (o *GoogleCloudLoggingHandlerOptions) validate() error {
+	if o.LogName == "" {
+		return errors.New("log name is required and cannot be empty")
+	}
+	if len(o.LogName) >= 512 || !logNameRegexp.MatchString(o.LogName) {
+		return fmt.Errorf("log name '%s' is invalid: it must be less than 512 characters long and may only contain "+
+			"letters, digits, forward-slashes, underscores, hyphens and periods", o.LogName)
+	}
+	if o.ProjectID == "" {
+		return errors.New("project ID is required and cannot be empty")
+	}
+	if !projectIDRegexp.MatchString(o.ProjectID) {
+		return fmt.Errorf("project ID '%s' is invalid: it must be 6 to 30 lowercase letters, digits or hyphens, "+
+			"start with a letter and not end with a hyphen", o.ProjectID)
 	}
-	if h.client != nil {
-		h.client.Close()
+	if o.DeriveTraceFromOTel && otelSpanContext == nil {
+		return errors.New("deriving traces from OpenTelemetry requires building with the 'otel' build tag")
 	}
 	return nil
 }
 
-// WithAttrs creates a new handler from the existing one adding the given attributes to it.
-func (h googleCloudLoggingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	newHandler := &googleCloudLoggingHandler{
-		attrs:   h.attrs,
-		client:  h.client,
-		futures: h.futures,
-		groups:  h.groups,
-		logger:  h.logger,
-		options: h.options,
-	}
-	if h.activeGroup == "" {
-		newHandler.attrs = append(newHandler.attrs, attrs...)
-	} else {
-		newHandler.attrs = append(newHandler.attrs, slog.Group(h.activeGroup, generic.AnySlice(attrs)...))
-		newHandler.activeGroup = h.activeGroup
+// DefaultGoogleCloudLoggingHandlerLevelMapper is a default function for mapping slog levels to GCP logging levels.
+//
+// Levels which fall between the named slogx levels are mapped to the severity of the nearest named level below them,
+// so slogx.LevelInfo+1 is mapped to logging.Info and slogx.LevelError+2 is mapped to logging.Error. Levels below
+// slogx.LevelInfo, including slogx.LevelTrace and any lower levels, are mapped to logging.Debug, while levels at or
+// above slogx.LevelPanic are mapped to logging.Emergency.
+//
+// Use StrictLevelMapper instead to be notified of levels which do not match a named slogx level.
+func DefaultGoogleCloudLoggingHandlerLevelMapper(level slog.Leveler) logging.Severity {
+	switch l := slogx.Level(level.Level()); {
+	case l >= slogx.LevelPanic:
+		return logging.Emergency
+	case l >= slogx.LevelFatal:
+		return logging.Critical
+	case l >= slogx.LevelError:
+		return logging.Error
+	case l >= slogx.LevelWarn:
+		return logging.Warning
+	case l >= slogx.LevelNotice:
+		return logging.Notice
+	case l >= slogx.LevelInfo:
+		return logging.Info
 	}
-	return newHandler
+	return logging.Debug
 }
 
-// WithGroup creates a new handler from the existing one adding the given group to it.
-func (h googleCloudLoggingHandler) WithGroup(name string) slog.Handler {
-	newHandler := &googleCloudLoggingHandler{
-		attrs:   h.attrs,
-		client:  h.client,
-		futures: h.futures,
-		groups:  h.groups,
-		logger:  h.logger,
-		options: h.options,
-	}
-	if name != "" {
-		newHandler.groups = append(newHandler.groups, name)
-		newHandler.activeGroup = name
+// StrictLevelMapper returns a function for mapping slog levels to GCP logging levels which only maps the named slogx
+// levels.
+//
+// The named levels are mapped to the same severities as DefaultGoogleCloudLoggingHandlerLevelMapper. Any other level
+// is passed to onUnmapped, if it is not nil, and mapped to logging.Default so that entries using it stand out in Cloud
+// Logging rather than silently taking the severity of a nearby level.
+func StrictLevelMapper(onUnmapped func(level slog.Leveler)) func(slog.Leveler) logging.Severity {
+	return func(level slog.Leveler) logging.Severity {
+		switch slogx.Level(level.Level()) {
+		case slogx.LevelTrace, slogx.LevelDebug, slogx.LevelInfo, slogx.LevelNotice, slogx.LevelWarn, slogx.LevelError,
+			slogx.LevelFatal, slogx.LevelPanic:
+			return DefaultGoogleCloudLoggingHandlerLevelMapper(level)
+		}
+		if onUnmapped != nil {
+			onUnmapped(level)
+		}
+		return logging.Default
 	}
-	return newHandler
 }
 
-// handle is responsible for actually posting the message to the HTTP listener.
-func (h googleCloudLoggingHandler) handle(ctx context.Context, r slog.Record) error {
-	attrs := slogx.ConsolidateAttrs(h.attrs, h.activeGroup, r)
+// EntryWriter is the interface used by the handler to write entries.
+//
+// The *logging.Logger type from the Google Cloud Logging client implements this interface.
+type EntryWriter interface {
+	// Flush sends any buffered entries.
+	Flush() error
 
-	// format the output into a buffer
-	var buf *slogx.Buffer
-	var err error
-	if h.options.RecordFormatter != nil {
-		buf, err = h.options.RecordFormatter.FormatRecord(ctx, r.Time, slogx.Level(r.Level), r.PC, r.Message,
-			attrs)
-	} else {
-		f := formatter.DefaultJSONFormatter()
-		buf, err = f.FormatRecord(ctx, r.Time, slogx.Level(r.Level), r.PC, r.Message, attrs)
-	}
-	if err != nil {
-		return err
-	}
+	// Log buffers the entry to be sent in the background.
+	Log(e logging.Entry)
 
-	// log the message synchronously since we're potentially already wrapped in a goroutine
-	var severity logging.Severity
-	if h.options.LevelMapper != nil {
-		severity = h.options.LevelMapper(r.Level)
-	} else {
-		severity = DefaultGoogleCloudLoggingHandlerLevelMapper(r.Level)
+	// LogSync sends the entry immediately.
+	LogSync(ctx context.Context, e logging.Entry) error
+}
+
+var _ EntryWriter = (*logging.Logger)(nil)
+
+// Handler is the interface implemented by handlers which write records to Google Cloud Logging.
+type Handler interface {
+	slog.Handler
+
+	// Flush waits for any pending records to be written without closing the handler.
+	Flush() error
+
+	// Shutdown waits for any pending records to be written and cleans up resources used by the handler.
+	Shutdown(continueOnError bool) error
+}
+
+// OptionsFormatter is implemented by formatters which read the handler's options from the context passed to
+// FormatRecord using GetGoogleCloudLoggingHandlerOptionsFromContext.
+//
+// Adding the options to the context requires an allocation for every record, so the handler only does so when the
+// RecordFormatter implements this interface and UsesHandlerOptions returns true.
+type OptionsFormatter interface {
+	formatter.BufferFormatter
+
+	// UsesHandlerOptions determines whether or not the formatter reads the handler's options from the context.
+	UsesHandlerOptions() bool
+}
+
+// panicError is the error returned when a panic is recovered while handling a record.
+type panicError struct {
+	value any
+}
+
+// Error returns the error message.
+func (e *panicError) Error() string {
+	return fmt.Sprintf("recovered from panic while handling record: %v", e.value)
+}
+
+// GoogleCloudLoggingHandler is a log handler that writes records to Google Cloud Logging.
+type GoogleCloudLoggingHandler struct {
+	attrs      []slog.Attr
+	fallback   *fallbackWriter
+	flusher    *autoFlusher
+	futures    *futureSet
+	groups     []string
+	lifecycle  *lifecycle
+	logger     EntryWriter
+	loggers    *loggerCache
+	options    GoogleCloudLoggingHandlerOptions
+	projects   *projectCache
+	writeSlots chan struct{}
+}
+
+var _ Handler = (*GoogleCloudLoggingHandler)(nil)
+
+// NewGoogleCloudLoggingHandler creates a new handler object.
+func NewGoogleCloudLoggingHandler(opts GoogleCloudLoggingHandlerOptions) (*GoogleCloudLoggingHandler, error) {
+	return NewGoogleCloudLoggingHandlerWithContext(context.Background(), opts)
+}
+
+// NewGoogleCloudLoggingHandlerWithContext creates a new handler object, using the given context when creating the
+// Google Cloud Logging client.
+//
+// When the LazyInit option is set, the client is created once the first entry is written instead, and only the
+// values of the context are used.
+//
+// If the ProjectID option is empty, the project ID is detected using the GCP metadata server.
+//
+// This function and NewGoogleCloudLoggingHandler are the only ones which create a client, so the client options
+// ClientCreationJitter, ClientOnError, ClientOptions, Endpoint and WithoutAuthentication are ignored by every other
+// constructor and by Clone.
+func NewGoogleCloudLoggingHandlerWithContext(ctx context.Context,
+	opts GoogleCloudLoggingHandlerOptions) (*GoogleCloudLoggingHandler, error) {
+	if opts.ProjectID == "" {
+		projectID, err := detectProjectID()
+		if err != nil {
+			return nil, fmt.Errorf("project ID was not supplied and could not be detected: %w", err)
+		}
+		opts.ProjectID = projectID
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts.setDefaults()
+	if opts.DryRun {
+		opts.DebugLogger("dry run enabled; no Google Cloud Logging client will be created")
+		return newGoogleCloudLoggingHandler(nil, nil, nil, opts), nil
+	}
+
+	// create the client, unless it should only be created once the first entry is written
+	newClient := func(ctx context.Context, projectID string) (*logging.Client, error) {
+		opts.DebugLogger(fmt.Sprintf("creating Google Cloud Logging client for project '%s'", projectID))
+		client, err := logging.NewClient(ctx, projectID, opts.clientOptions()...)
+		if err != nil {
+			return nil, classifyError(err)
+		}
+		if opts.ClientOnError != nil {
+			client.OnError = opts.ClientOnError
+		}
+		return client, nil
+	}
+	// clients created after the handler must not be tied to the context, which may have been canceled by then
+	clientCtx := context.WithoutCancel(ctx)
+	var client *sharedClient
+	if opts.LazyInit {
+		client = newLazyClient(func() (*logging.Client, error) {
+			if err := waitJitter(clientCtx, opts.ClientCreationJitter); err != nil {
+				return nil, err
+			}
+			return newClient(clientCtx, opts.ProjectID)
+		})
+	} else {
+		if err := waitJitter(ctx, opts.ClientCreationJitter); err != nil {
+			return nil, err
+		}
+		c, err := newClient(ctx, opts.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+		client = newSharedClient(c)
+	}
+	logger := client.logger(opts.LogName, opts.loggerOptions(), opts.ClientOnError)
+	clientRefs := &atomic.Int32{}
+	clientRefs.Store(1)
+	h := newGoogleCloudLoggingHandler(client, clientRefs, logger, opts)
+	if opts.ProjectRouter != nil {
+		h.projects = newProjectCache(func(projectID string) (*logging.Client, error) {
+			return newClient(clientCtx, projectID)
+		}, opts.loggerOptions(), opts.LogName)
+	}
+	return h, nil
+}
+
+// NewGoogleCloudLoggingHandlerWithClient creates a new handler object which writes records using the given client.
+//
+// The caller owns the client's lifecycle, so the client is not closed when the handler is shut down.
+func NewGoogleCloudLoggingHandlerWithClient(client *logging.Client,
+	opts GoogleCloudLoggingHandlerOptions) (*GoogleCloudLoggingHandler, error) {
+	if client == nil {
+		return nil, errors.New("client is required and cannot be nil")
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts.setDefaults()
+	logger := client.Logger(opts.LogName, opts.loggerOptions()...)
+	return newGoogleCloudLoggingHandler(newSharedClient(client), nil, logger, opts), nil
+}
+
+// NewGoogleCloudLoggingHandlerWithWriter creates a new handler object which writes entries to the given writer
+// instead of to Google Cloud Logging.
+//
+// This is primarily useful for testing code which uses the handler without requiring access to Google Cloud Logging.
+// Since no logger is created, the LoggerOptions and MonitoredResource options and the other options passed to the
+// client's underlying logger are ignored along with the client options.
+func NewGoogleCloudLoggingHandlerWithWriter(w EntryWriter,
+	opts GoogleCloudLoggingHandlerOptions) (*GoogleCloudLoggingHandler, error) {
+	if w == nil {
+		return nil, errors.New("writer is required and cannot be nil")
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts.setDefaults()
+	return newGoogleCloudLoggingHandler(nil, nil, w, opts), nil
+}
+
+// NewLogger creates a new handler object and wraps it in a new slog.Logger.
+//
+// The returned function shuts down the handler, waiting for any pending records to be written, and should be called
+// before the application exits.
+func NewLogger(opts GoogleCloudLoggingHandlerOptions) (*slog.Logger, func() error, error) {
+	handler, err := NewGoogleCloudLoggingHandler(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return slog.New(handler), func() error { return handler.Shutdown(true) }, nil
+}
+
+// detectProjectID detects the ID of the GCP project in which the process is running using the GCP metadata server.
+var detectProjectID = func() (string, error) {
+	if !metadata.OnGCE() {
+		return "", errors.New("the GCP metadata server is not available")
+	}
+	return metadata.ProjectID()
+}
+
+// waitJitter waits for a random duration of up to maxDelay, returning the context's error if it is canceled first.
+func waitJitter(ctx context.Context, maxDelay time.Duration) error {
+	if maxDelay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(maxDelay))))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newGoogleCloudLoggingHandler creates a new handler object using the given client, writer and options.
+//
+// If clientRefs is nil, the client is owned by the caller and is never closed by the handler. Otherwise the client is
+// closed once the count of handlers sharing it drops to zero. When the DryRun option is set, the client and writer
+// are ignored and entries are written to the DryRunWriter instead.
+func newGoogleCloudLoggingHandler(client *sharedClient, clientRefs *atomic.Int32, logger EntryWriter,
+	opts GoogleCloudLoggingHandlerOptions) *GoogleCloudLoggingHandler {
+	if opts.DryRun {
+		client, clientRefs, logger = nil, nil, &dryRunWriter{resource: opts.MonitoredResource, w: opts.DryRunWriter}
+	}
+	opts.Labels = maps.Clone(opts.Labels)
+	opts.LevelSeverityOverrides = maps.Clone(opts.LevelSeverityOverrides)
+	opts.RedactKeys = slices.Clone(opts.RedactKeys)
+	var writeSlots chan struct{}
+	if opts.MaxConcurrentWrites > 0 {
+		writeSlots = make(chan struct{}, opts.MaxConcurrentWrites)
+	}
+	var fallback *fallbackWriter
+	if opts.FallbackWriter != nil {
+		fallback = &fallbackWriter{w: opts.FallbackWriter}
+	}
+	var loggers *loggerCache
+	if client != nil {
+		loggers = newLoggerCache(client, opts.loggerOptions(), opts.ClientOnError, opts.LogName, logger)
+	}
+	h := &GoogleCloudLoggingHandler{
+		attrs:      []slog.Attr{},
+		fallback:   fallback,
+		futures:    &futureSet{futures: []*trackedFuture{}},
+		groups:     []string{},
+		lifecycle:  newLifecycle(client, clientRefs),
+		logger:     logger,
+		loggers:    loggers,
+		options:    opts,
+		writeSlots: writeSlots,
+	}
+	if opts.AutoFlushInterval > 0 {
+		h.flusher = newAutoFlusher(opts.AutoFlushInterval, h.flushInBackground)
+	}
+	return h
+}
+
+// Clone creates a new handler using the given options which shares the existing handler's client.
+//
+// The new handler writes to the log named by the LogName option using a new logger created from the shared client,
+// or to the same writer if the existing handler was created using NewGoogleCloudLoggingHandlerWithWriter. A client
+// created by the handler is only closed once every handler sharing it has been shut down. ErrHandlerShutdown is
+// returned if the existing handler has already been shut down or the client it shares has already been closed.
+//
+// A writer shared with the clone is owned by the caller, so it is never closed: shutting down either handler only
+// flushes the writer, and the other handler can continue writing to it.
+//
+// If the ProjectID option is empty, the existing handler's project ID is used. Clients created for other projects
+// using the ProjectRouter option are not shared; the new handler creates and closes its own.
+func (h *GoogleCloudLoggingHandler) Clone(opts GoogleCloudLoggingHandlerOptions) (*GoogleCloudLoggingHandler, error) {
+	if opts.ProjectID == "" {
+		opts.ProjectID = h.options.ProjectID
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts.setDefaults()
+
+	// hold the lifecycle's lock so the client cannot be closed by Shutdown before the clone holds a reference to it
+	h.lifecycle.lock.Lock()
+	defer h.lifecycle.lock.Unlock()
+	if h.lifecycle.isClosed() {
+		return nil, ErrHandlerShutdown
+	}
+	if h.lifecycle.client == nil {
+		return newGoogleCloudLoggingHandler(nil, nil, h.logger, opts), nil
+	}
+	if !h.lifecycle.retain() {
+		return nil, ErrHandlerShutdown
+	}
+	logger := h.lifecycle.client.logger(opts.LogName, opts.loggerOptions(), opts.ClientOnError)
+	clone := newGoogleCloudLoggingHandler(h.lifecycle.client, h.lifecycle.clientRefs, logger, opts)
+	if h.projects != nil && opts.ProjectRouter != nil {
+		clone.projects = newProjectCache(h.projects.newClient, opts.loggerOptions(), opts.LogName)
+	}
+	return clone, nil
+}
+
+// Enabled determines whether or not the given level is enabled in this handler.
+func (h *GoogleCloudLoggingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.options.Level.Level()
+}
+
+// Handle actually handles posting the record to the HTTP listener.
+//
+// Records below the handler's level are discarded, even if Enabled() was not checked by the caller, as are records
+// rejected by the Sampler. Records logged after the handler has been shut down are discarded and ErrHandlerShutdown
+// is returned.
+//
+// Any attributes duplicated between the handler and record, including within groups, are automaticlaly removed
+// unless the DisableAttrDeduplication option is set. If a duplicate is encountered, the last value found will be
+// used for the attribute's value.
+func (h *GoogleCloudLoggingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if !h.Enabled(ctx, r.Level) {
+		return nil
+	}
+	if h.options.Sampler != nil && !h.options.Sampler(r) {
+		return nil
+	}
+	if h.lifecycle.isClosed() {
+		return ErrHandlerShutdown
+	}
+
+	handlerCtx := ctx
+	if f, ok := h.options.RecordFormatter.(OptionsFormatter); ok && f.UsesHandlerOptions() {
+		handlerCtx = h.options.AddToContext(ctx)
+	}
+	if !h.options.EnableAsync {
+		err := h.handleRecovered(handlerCtx, r)
+		var panicErr *panicError
+		if errors.As(err, &panicErr) && h.options.OnError != nil {
+			h.options.OnError(err, r)
+		}
+		return err
+	}
+	return h.handleAsync(handlerCtx, r)
+}
+
+// Flush waits for any pending records to be written and flushes any entries buffered by the underlying logger,
+// including the loggers created for other logs using the LogNameFunc option.
+//
+// Unlike Shutdown(), the client is left open so the handler can continue to be used after it is flushed.
+func (h *GoogleCloudLoggingHandler) Flush() error {
+	return h.flush(true, 0)
+}
+
+// Options returns a copy of the options in effect for the handler, after any defaults have been applied.
+//
+// Maps and slices are copied, so changes made to the returned options have no effect on the handler. Values held by
+// pointer or interface, such as the Level, MonitoredResource and RecordFormatter, are shared with the handler.
+func (h *GoogleCloudLoggingHandler) Options() GoogleCloudLoggingHandlerOptions {
+	opts := h.options
+	opts.ClientOptions = slices.Clone(opts.ClientOptions)
+	opts.CommonLabels = maps.Clone(opts.CommonLabels)
+	opts.Labels = maps.Clone(opts.Labels)
+	opts.LevelSeverityOverrides = maps.Clone(opts.LevelSeverityOverrides)
+	opts.LoggerOptions = slices.Clone(opts.LoggerOptions)
+	opts.RedactKeys = slices.Clone(opts.RedactKeys)
+	return opts
+}
+
+// Shutdown is responsible for cleaning up resources used by the handler.
+//
+// Every logger used by the handler is flushed before the loggers are released and the client is closed.
+//
+// If continueOnError is false, the first error encountered while waiting for pending records to be written is
+// returned as soon as the client has been closed. Otherwise, all pending records are waited on and any errors
+// encountered are combined into a single error.
+//
+// If the ShutdownTimeout option is set and pending records are still being written once it elapses, Shutdown stops
+// waiting, closes the client in the background and returns an error wrapping ErrShutdownTimeout.
+//
+// Shutdown is shared by the handler and any handlers derived from it using WithAttrs or WithGroup, so shutting down
+// any one of them shuts down all of them. Only the first call does any work; subsequent calls return nil. Records
+// logged through any of these handlers after Shutdown has been called are discarded and Handle returns
+// ErrHandlerShutdown.
+func (h *GoogleCloudLoggingHandler) Shutdown(continueOnError bool) error {
+	h.lifecycle.lock.Lock()
+	defer h.lifecycle.lock.Unlock()
+	if h.lifecycle.isClosed() {
+		return nil
+	}
+	h.lifecycle.closed.Store(true)
+	h.futures.close()
+
+	if h.flusher != nil {
+		h.flusher.close()
+	}
+	if h.loggers != nil {
+		defer h.loggers.clear()
+	}
+	err := h.flush(continueOnError, h.options.ShutdownTimeout)
+	if errors.Is(err, ErrShutdownTimeout) {
+		// closing the client flushes any buffered entries, which may block just like the pending writes did
+		go h.closeClients()
+		return err
+	}
+	if err != nil && !continueOnError {
+		h.closeClients()
+		return err
+	}
+	if closeErr := h.closeClients(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	return err
+}
+
+// WithAttrs creates a new handler from the existing one adding the given attributes to it.
+//
+// The attributes are nested under the full path of groups added to the handler using WithGroup(). Any values which
+// implement slog.LogValuer are resolved when the attributes are added. If there are no attributes to add, the existing
+// handler is returned unchanged.
+func (h *GoogleCloudLoggingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if attrs = dropEmptyGroups(resolveAttrs(attrs)); len(attrs) == 0 {
+		return h
+	}
+	newHandler := &GoogleCloudLoggingHandler{
+		attrs:      h.attrs,
+		fallback:   h.fallback,
+		flusher:    h.flusher,
+		futures:    h.futures,
+		groups:     h.groups,
+		lifecycle:  h.lifecycle,
+		logger:     h.logger,
+		loggers:    h.loggers,
+		options:    h.options,
+		projects:   h.projects,
+		writeSlots: h.writeSlots,
+	}
+	newHandler.attrs = append(slices.Clip(newHandler.attrs), nestAttrs(h.groups, attrs)...)
+	return newHandler
+}
+
+// WithGroup creates a new handler from the existing one adding the given group to it.
+//
+// If the name is empty, the existing handler is returned unchanged.
+func (h *GoogleCloudLoggingHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	newHandler := &GoogleCloudLoggingHandler{
+		attrs:      h.attrs,
+		fallback:   h.fallback,
+		flusher:    h.flusher,
+		futures:    h.futures,
+		groups:     h.groups,
+		lifecycle:  h.lifecycle,
+		logger:     h.logger,
+		loggers:    h.loggers,
+		options:    h.options,
+		projects:   h.projects,
+		writeSlots: h.writeSlots,
+	}
+	newHandler.groups = append(slices.Clip(newHandler.groups), name)
+	return newHandler
+}
+
+// closeClients closes the handler's client, if it is no longer shared, along with any clients created for other
+// projects using the ProjectRouter option.
+func (h *GoogleCloudLoggingHandler) closeClients() error {
+	err := h.lifecycle.closeClient()
+	if h.projects != nil {
+		err = errors.Join(err, h.projects.close())
+	}
+	return err
+}
+
+// flushInBackground flushes any entries buffered by the handler's loggers and removes completed asynchronous writes
+// from the handler's pending writes, without waiting for the writes which are still in progress.
+//
+// It is called periodically when the AutoFlushInterval option is set. Errors are ignored since they are returned by
+// the loggers again on the next explicit flush.
+func (h *GoogleCloudLoggingHandler) flushInBackground() {
+	h.futures.reap()
+	if h.logger != nil {
+		_ = h.logger.Flush()
+	}
+	if h.loggers != nil {
+		_ = h.loggers.flush(h.logger, true)
+	}
+	if h.projects != nil {
+		_ = h.projects.flush(true)
+	}
+}
+
+// flush waits for any pending records to be written and flushes the underlying logger.
+//
+// If continueOnError is false, the first error encountered is returned immediately. Otherwise all errors encountered
+// are combined into a single error.
+//
+// If timeout is greater than 0 and pending records are still being written once it elapses, an error wrapping
+// ErrShutdownTimeout is returned and the underlying logger is not flushed.
+func (h *GoogleCloudLoggingHandler) flush(continueOnError bool, timeout time.Duration) error {
+	futures := h.futures.take()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	errs := []error{}
+	for i, f := range futures {
+		if f == nil {
+			continue
+		}
+		select {
+		case <-f.done:
+		case <-expired:
+			return fmt.Errorf("%w: %d writes still pending after %s", ErrShutdownTimeout,
+				countPending(futures[i:]), timeout)
+		}
+		if err, ok := f.Await().(error); ok && err != nil {
+			if !continueOnError {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	if h.logger != nil {
+		if err := h.logger.Flush(); err != nil {
+			if !continueOnError {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	if h.loggers != nil {
+		if err := h.loggers.flush(h.logger, continueOnError); err != nil {
+			if !continueOnError {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	if h.projects != nil {
+		if err := h.projects.flush(continueOnError); err != nil {
+			if !continueOnError {
+				return err
+			}
+			errs = append(errs, err)
+		}
 	}
-	err = h.logger.LogSync(ctx, logging.Entry{
+	return errors.Join(errs...)
+}
+
+// handle is responsible for actually posting the message to the HTTP listener.
+func (h *GoogleCloudLoggingHandler) handle(ctx context.Context, r slog.Record) error {
+	// records built without a time would otherwise be written with a timestamp in the year 1
+	if r.Time.IsZero() && !h.options.PreserveZeroTime {
+		r.Time = time.Now()
+	}
+	if h.options.TimestampLocation != nil {
+		r.Time = r.Time.In(h.options.TimestampLocation)
+	}
+	if h.options.TimestampPrecision > 0 {
+		r.Time = r.Time.Truncate(h.options.TimestampPrecision)
+	}
+	attrs := h.redactAttrs(h.consolidateAttrs(r))
+	if h.options.AddCallerField && r.PC != 0 {
+		frame := callerFrame(r.PC)
+		attrs = append(attrs, slog.String(h.options.CallerFieldKey, fmt.Sprintf("%s:%d", frame.File, frame.Line)))
+	}
+	if h.options.IncludeTimestampInPayload && !r.Time.IsZero() {
+		attrs = append(attrs, slog.String(h.options.TimestampPayloadKey, r.Time.Format(time.RFC3339Nano)))
+	}
+	var correlationID string
+	if h.options.CorrelationIDExtractor != nil {
+		if correlationID = h.options.CorrelationIDExtractor(ctx); correlationID != "" {
+			attrs = append(attrs, slog.String(h.options.CorrelationIDKey, correlationID))
+		}
+	}
+	attrs, special := extractSpecialFields(attrs)
+	attrs, attrLabels := h.promoteLabelAttrs(attrs)
+	if special.labels != nil {
+		attrLabels = mergeLabels(special.labels, attrLabels)
+	}
+	if correlationID != "" && h.options.CorrelationIDLabelKey != "" {
+		attrLabels = mergeLabels(attrLabels, map[string]string{h.options.CorrelationIDLabelKey: correlationID})
+	}
+
+	// determine the severity of the entry, discarding it if it's not severe enough
+	severity := h.severityFor(r.Level)
+	if h.options.PromoteOnErrorAttr && severity < logging.Error && h.hasErrorAttr(attrs) {
+		severity = logging.Error
+	}
+	severity = escalateSeverity(ctx, severity)
+	if h.options.SeverityFromPayloadKey == "" && h.belowMinSeverity(ctx, severity) {
+		return nil
+	}
+
+	// format the output into a buffer
+	var pooled *[]byte
+	defer func() {
+		if pooled != nil && cap(*pooled) <= maxPooledPayloadBytes {
+			payloadBufferPool.Put(pooled)
+		}
+	}()
+	f := h.options.RecordFormatter
+	if f == nil {
+		f = formatter.DefaultJSONFormatter()
+	}
+	buf, err := f.FormatRecord(ctx, r.Time, slogx.Level(r.Level), r.PC, r.Message, attrs)
+	if err != nil {
+		return err
+	}
+
+	// make sure the formatter produced a valid JSON payload, falling back to a text payload if necessary, unless a
+	// text payload was requested
+	var payload []byte
+	if buf != nil {
+		payload = buf.Bytes()
+	}
+	var entryPayload any
+	if h.options.PayloadType == PayloadTypeText {
+		entryPayload = string(payload)
+	} else if len(bytes.TrimSpace(payload)) == 0 || !json.Valid(payload) {
+		if !h.options.FallbackToTextPayload {
+			return fmt.Errorf("formatter %T did not produce a valid JSON payload", f)
+		}
+		payload = []byte(r.Message)
+		entryPayload = r.Message
+	} else {
+		if h.options.SeverityFromPayloadKey != "" {
+			if payloadSeverity, ok := severityFromPayload(payload, h.options.SeverityFromPayloadKey); ok {
+				severity = escalateSeverity(ctx, payloadSeverity)
+			}
+		}
+		if h.options.DisableHTMLEscape {
+			payload = unescapeHTML(payload)
+		}
+		// the processed payload is encoded into a pooled buffer, which is reused once the entry has been written, if
+		// the writer is finished with the entry by then
+		var buf []byte
+		if h.releasesEntries(h.logger) {
+			pooled = payloadBufferPool.Get().(*[]byte)
+			buf = (*pooled)[:0]
+		}
+		if payload, err = h.processPayload(r, severity, payload, buf); err != nil {
+			return err
+		}
+		if pooled != nil {
+			*pooled = payload[:0]
+		}
+		entryPayload = json.RawMessage(payload)
+	}
+	if h.options.SeverityFromPayloadKey != "" && h.belowMinSeverity(ctx, severity) {
+		return nil
+	}
+	if h.options.AfterFormat != nil {
+		if payload, err = h.options.AfterFormat(ctx, payload); err != nil {
+			return err
+		}
+		if _, ok := entryPayload.(json.RawMessage); !ok {
+			entryPayload = string(payload)
+		} else if !json.Valid(payload) {
+			return errors.New("AfterFormat did not produce a valid JSON payload")
+		} else {
+			entryPayload = json.RawMessage(payload)
+		}
+	}
+	if h.options.MaxPayloadBytes > 0 && len(payload) > h.options.MaxPayloadBytes {
+		payload, entryPayload = h.limitPayload(r, payload, entryPayload)
+	}
+
+	// build the entry to send to the logger
+	entry := logging.Entry{
 		Timestamp: r.Time,
 		Severity:  severity,
-		Payload:   json.RawMessage(buf.Bytes()),
+		Payload:   entryPayload,
+	}
+	if h.options.HTTPRequestExtractor != nil {
+		if req := h.options.HTTPRequestExtractor(ctx, attrs); req != nil {
+			entry.HTTPRequest = req
+		}
+	}
+	if h.options.IncludeSourceLocation && r.PC != 0 {
+		frame := callerFrame(r.PC)
+		entry.SourceLocation = &loggingpb.LogEntrySourceLocation{
+			File:     frame.File,
+			Line:     int64(frame.Line),
+			Function: frame.Function,
+		}
+	}
+	if h.options.InsertIDFunc != nil {
+		if insertID := h.options.InsertIDFunc(r, attrs); insertID != "" {
+			entry.InsertID = insertID
+		}
+	}
+	if labels := h.labels(ctx, r, attrs, attrLabels); labels != nil {
+		entry.Labels = labels
+	}
+	if h.options.OperationExtractor != nil {
+		if op := h.options.OperationExtractor(ctx); op != nil {
+			entry.Operation = op
+		}
+	}
+	if h.options.ResourceExtractor != nil {
+		if resource := h.options.ResourceExtractor(ctx, r); resource != nil {
+			entry.Resource = resource
+		}
+	}
+	projectID := h.projectFor(r, attrs)
+	if special.trace != "" {
+		entry.Trace = h.traceName(projectID, special.trace)
+		entry.TraceSampled = special.traceSampled
+	}
+	if special.spanID != "" {
+		entry.SpanID = special.spanID
+	}
+	if h.options.DeriveTraceFromOTel {
+		if traceID, spanID, sampled := otelSpanContext(ctx); traceID != "" {
+			entry.Trace = h.traceName(projectID, traceID)
+			entry.SpanID = spanID
+			entry.TraceSampled = sampled
+		}
+	}
+	if h.options.TraceExtractor != nil {
+		// the span is only meaningful within the trace, so it is ignored if no trace is returned
+		if traceID, spanID, sampled := h.options.TraceExtractor(ctx); traceID != "" {
+			entry.Trace = h.traceName(projectID, traceID)
+			entry.SpanID = spanID
+			entry.TraceSampled = sampled
+		}
+	}
+
+	// write the entry, reporting the outcome to any configured callbacks
+	logger, err := h.entryLogger(r, attrs, projectID)
+	start := time.Now()
+	if err == nil {
+		err = h.write(ctx, logger, entry)
+	}
+	latency := time.Since(start)
+	if err != nil {
+		if h.options.OnWriteFailure != nil {
+			h.options.OnWriteFailure(err, latency, severity)
+		}
+		if h.fallback != nil {
+			if fallbackErr := h.fallback.write(entry, err); fallbackErr != nil {
+				err = errors.Join(err, fallbackErr)
+			}
+		}
+		return err
+	}
+	if h.options.OnWriteSuccess != nil {
+		h.options.OnWriteSuccess(len(payload), latency, severity)
+	}
+	return nil
+}
+
+// belowMinSeverity determines whether or not an entry of the given severity should be discarded for being below the
+// MinSeverity option or the threshold carried by the context.
+func (h *GoogleCloudLoggingHandler) belowMinSeverity(ctx context.Context, severity logging.Severity) bool {
+	return severity < h.options.MinSeverity || belowSeverityThreshold(ctx, severity)
+}
+
+// handleRecovered calls handle, converting any panic raised while handling the record into an error.
+func (h *GoogleCloudLoggingHandler) handleRecovered(ctx context.Context, r slog.Record) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &panicError{value: p}
+		}
+	}()
+	return h.handle(ctx, r)
+}
+
+// handleAsync writes the record in a separate goroutine.
+func (h *GoogleCloudLoggingHandler) handleAsync(ctx context.Context, r slog.Record) error {
+	// wait for an available write slot if the number of concurrent writes is limited
+	if h.writeSlots != nil {
+		if h.options.DropOnMaxConcurrentWrites {
+			select {
+			case h.writeSlots <- struct{}{}:
+			default:
+				if h.options.OnError != nil {
+					h.options.OnError(ErrWriteDropped, r)
+				}
+				return nil
+			}
+		} else {
+			select {
+			case h.writeSlots <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	future := newTrackedFuture()
+	err := h.futures.add(future, func() async.Future {
+		return async.Exec(func() any {
+			defer func() {
+				if h.writeSlots != nil {
+					<-h.writeSlots
+				}
+				close(future.done)
+			}()
+			var err error
+			if ctxErr := ctx.Err(); ctxErr != nil && h.options.SkipOnCanceledContext {
+				err = ctxErr
+			} else {
+				err = h.handleRecovered(ctx, r)
+			}
+			if err != nil && h.options.OnError != nil {
+				h.options.OnError(err, r)
+			}
+			return err
+		})
 	})
+	if err != nil && h.writeSlots != nil {
+		// the handler was shut down after Handle checked, so the write was never started
+		<-h.writeSlots
+	}
 	return err
 }
+
+// hasErrorAttr determines whether or not the given attributes contain a non-nil error attribute at the top level.
+func (h *GoogleCloudLoggingHandler) hasErrorAttr(attrs []slog.Attr) bool {
+	for _, attr := range attrs {
+		if h.options.ErrorAttrKey != "" {
+			if attr.Key != h.options.ErrorAttrKey {
+				continue
+			}
+		} else if attr.Key != "err" && attr.Key != "error" {
+			continue
+		}
+		if attr.Value.Kind() == slog.KindAny && attr.Value.Any() == nil {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// callerFrame resolves the given program counter into the frame from which the record was logged.
+func callerFrame(pc uintptr) runtime.Frame {
+	frames := runtime.CallersFrames([]uintptr{pc})
+	frame, _ := frames.Next()
+	return frame
+}
+
+// consolidateAttrs merges the handler's attributes with the record's attributes, nesting the record's attributes
+// under the full path of groups added to the handler.
+//
+// Any of the record's values which implement slog.LogValuer are resolved first, so the values seen by redaction, label
+// promotion and the various extractors are the resolved values. If the DisableAttrDeduplication option is set, the
+// attributes are simply combined in the order they were added.
+func (h *GoogleCloudLoggingHandler) consolidateAttrs(r slog.Record) []slog.Attr {
+	recordAttrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(attr slog.Attr) bool {
+		recordAttrs = append(recordAttrs, attr)
+		return true
+	})
+	recordAttrs = dropEmptyGroups(resolveAttrs(recordAttrs))
+	if h.options.DisableAttrDeduplication {
+		if len(recordAttrs) == 0 {
+			return slices.Clip(h.attrs)
+		}
+		return append(slices.Clip(h.attrs), nestAttrs(h.groups, recordAttrs)...)
+	}
+	nested := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	if len(recordAttrs) > 0 {
+		nested.AddAttrs(nestAttrs(h.groups, recordAttrs)...)
+	}
+	return slogx.ConsolidateAttrs(h.attrs, "", nested)
+}
+
+// resolveAttrs resolves the values of any of the given attributes which implement slog.LogValuer, including those
+// within groups and those which resolve to groups.
+//
+// The given slice is returned as-is if it does not contain any values which need to be resolved.
+func resolveAttrs(attrs []slog.Attr) []slog.Attr {
+	var result []slog.Attr
+	for i, attr := range attrs {
+		value := attr.Value
+		changed := value.Kind() == slog.KindLogValuer
+		if changed {
+			value = value.Resolve()
+		}
+		if value.Kind() == slog.KindGroup {
+			group := value.Group()
+			if resolved := resolveAttrs(group); len(group) > 0 && &resolved[0] != &group[0] {
+				value, changed = slog.GroupValue(resolved...), true
+			}
+		}
+		if changed && result == nil {
+			result = make([]slog.Attr, i, len(attrs))
+			copy(result, attrs[:i])
+		}
+		if result != nil {
+			result = append(result, slog.Attr{Key: attr.Key, Value: value})
+		}
+	}
+	if result == nil {
+		return attrs
+	}
+	return result
+}
+
+// dropEmptyGroups removes any groups which contain no attributes, including groups which only contain other empty
+// groups, from the given attributes.
+//
+// The given slice is returned as-is if it does not contain any empty groups.
+func dropEmptyGroups(attrs []slog.Attr) []slog.Attr {
+	var result []slog.Attr
+	for i, attr := range attrs {
+		if attr.Value.Kind() == slog.KindGroup {
+			group := attr.Value.Group()
+			pruned := dropEmptyGroups(group)
+			if len(pruned) == 0 || len(pruned) != len(group) || &pruned[0] != &group[0] {
+				// the group changed, so copy the attributes checked so far if that hasn't happened already
+				if result == nil {
+					result = make([]slog.Attr, i, len(attrs))
+					copy(result, attrs[:i])
+				}
+				if len(pruned) > 0 {
+					result = append(result, slog.Attr{Key: attr.Key, Value: slog.GroupValue(pruned...)})
+				}
+				continue
+			}
+		}
+		if result != nil {
+			result = append(result, attr)
+		}
+	}
+	if result == nil {
+		return attrs
+	}
+	return result
+}
+
+// nestAttrs nests the given attributes under the given path of groups, with the first group being the outermost.
+func nestAttrs(groups []string, attrs []slog.Attr) []slog.Attr {
+	for i := len(groups) - 1; i >= 0; i-- {
+		attrs = []slog.Attr{slog.Group(groups[i], generic.AnySlice(attrs)...)}
+	}
+	return attrs
+}
+
+// severityFor returns the Google Cloud Logging severity of the given level.
+//
+// The LevelSeverityOverrides option takes precedence, followed by the LevelMapper and finally the default mapper.
+func (h *GoogleCloudLoggingHandler) severityFor(level slog.Leveler) logging.Severity {
+	if override, ok := h.options.LevelSeverityOverrides[slogx.Level(level.Level())]; ok {
+		return override
+	}
+	if h.options.LevelMapper != nil {
+		return h.options.LevelMapper(level)
+	}
+	return DefaultGoogleCloudLoggingHandlerLevelMapper(level)
+}
+
+// traceName returns the full resource name of the given trace, qualifying it with the given project ID if necessary.
+func (h *GoogleCloudLoggingHandler) traceName(projectID, traceID string) string {
+	if strings.HasPrefix(traceID, "projects/") {
+		return traceID
+	}
+	return fmt.Sprintf("projects/%s/traces/%s", projectID, traceID)
+}
+
+// projectFor returns the ID of the project the given record is written to, taking the ProjectRouter option into
+// account.
+func (h *GoogleCloudLoggingHandler) projectFor(r slog.Record, attrs []slog.Attr) string {
+	if h.options.ProjectRouter != nil && h.projects != nil {
+		if projectID := h.options.ProjectRouter(r, attrs); projectID != "" {
+			return projectID
+		}
+	}
+	return h.options.ProjectID
+}
+
+// write writes the entry using the given writer, retrying failed synchronous writes if necessary.
+func (h *GoogleCloudLoggingHandler) write(ctx context.Context, logger EntryWriter, entry logging.Entry) error {
+	if h.options.buffered() {
+		logger.Log(entry)
+		return nil
+	}
+	if h.options.RetryPolicy != nil {
+		return h.options.RetryPolicy.retry(ctx, func(ctx context.Context) error {
+			return h.logSync(ctx, logger, entry)
+		})
+	}
+	return h.logSync(ctx, logger, entry)
+}
+
+// releasesEntries determines whether or not the given writer is finished with an entry once it has been written, so
+// the buffer holding the entry's payload can be reused.
+//
+// Buffered and batched entries are written after handle returns, and writers supplied by the caller may keep the
+// entries they are given, so their payloads are never reused.
+func (h *GoogleCloudLoggingHandler) releasesEntries(logger EntryWriter) bool {
+	if h.options.buffered() {
+		return false
+	}
+	switch logger.(type) {
+	case *logging.Logger, *lazyLogger, *dryRunWriter:
+		return true
+	}
+	return false
+}
+
+// entryLogger returns the writer to use for the given record in the given project, taking the LogNameFunc option into
+// account.
+//
+// An error is returned if a client could not be created for the project chosen by the ProjectRouter.
+func (h *GoogleCloudLoggingHandler) entryLogger(r slog.Record, attrs []slog.Attr, projectID string) (EntryWriter,
+	error) {
+	name := h.options.LogName
+	if h.options.LogNameFunc != nil {
+		if n := h.options.LogNameFunc(r, attrs); n != "" {
+			name = n
+		}
+	}
+	if projectID != h.options.ProjectID {
+		return h.projects.get(projectID, name)
+	}
+	if name == h.options.LogName || h.loggers == nil {
+		return h.logger, nil
+	}
+	return h.loggers.get(name), nil
+}
+
+// logSync synchronously writes the entry, enforcing the write timeout if one is configured.
+func (h *GoogleCloudLoggingHandler) logSync(ctx context.Context, logger EntryWriter, entry logging.Entry) error {
+	if h.options.WriteTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.options.WriteTimeout)
+		defer cancel()
+	}
+	return classifyError(logger.LogSync(ctx, entry))
+}
+
+// limitPayload shortens the given payload so it is no larger than the MaxPayloadBytes option, reporting the
+// truncation to OnError.
+//
+// Text payloads are simply cut short. For JSON payloads, the largest string fields, including those within nested
+// objects, are shortened first, and if that is not enough the payload is replaced with one containing only the
+// shortened message.
+func (h *GoogleCloudLoggingHandler) limitPayload(r slog.Record, payload []byte, entryPayload any) ([]byte, any) {
+	maxBytes := h.options.MaxPayloadBytes
+	if h.options.OnError != nil {
+		h.options.OnError(fmt.Errorf("payload of %d bytes exceeds the maximum of %d bytes and was truncated",
+			len(payload), maxBytes), r)
+	}
+
+	if text, ok := entryPayload.(string); ok {
+		text = truncateString(text, max(maxBytes-len(truncatedSuffix), 0)) + truncatedSuffix
+		return []byte(text), text
+	}
+	if obj, ok := parsePayloadObject(payload); ok {
+		if obj, ok = obj.truncate(maxBytes); ok {
+			payload = obj.bytes()
+			return payload, json.RawMessage(payload)
+		}
+	}
+
+	// as a last resort, keep as much of the message as possible
+	obj, _ := payloadObject{}.set("truncated", true)
+	obj, _ = obj.set(h.options.MessageKey, "")
+	overhead := len(obj.bytes()) + len(truncatedSuffix)
+	obj, _ = obj.set(h.options.MessageKey, truncateString(r.Message, max(maxBytes-overhead, 0))+truncatedSuffix)
+	payload = obj.bytes()
+	return payload, json.RawMessage(payload)
+}
+
+// messageKeys returns the keys under which formatters commonly place the record's message, including the given
+// message key.
+func messageKeys(messageKey string) []string {
+	keys := []string{slog.MessageKey, DefaultMessageKey}
+	if !slices.Contains(keys, messageKey) {
+		keys = append(keys, messageKey)
+	}
+	return keys
+}
+
+// processPayload makes any necessary changes to the JSON payload produced by the formatter, appending the processed
+// payload to the given buffer.
+//
+// Payloads which are not JSON objects are returned unchanged.
+func (h *GoogleCloudLoggingHandler) processPayload(r slog.Record, severity logging.Severity, payload []byte,
+	buf []byte) ([]byte, error) {
+	obj, ok := parsePayloadObject(payload)
+	if !ok {
+		return payload, nil
+	}
+	obj, err := obj.set(h.options.MessageKey, r.Message)
+	if err != nil {
+		return nil, err
+	}
+	if h.options.DedupeMessage {
+		obj = obj.dedupeMessage(h.options.MessageKey, messageKeys(h.options.MessageKey), r.Message)
+	}
+	if h.options.EnableErrorReporting && severity >= logging.Error {
+		if obj, err = h.applyErrorReporting(obj, r); err != nil {
+			return nil, err
+		}
+	} else if h.options.ServiceContext != (ServiceContext{}) {
+		if obj, err = obj.set("serviceContext", h.options.ServiceContext); err != nil {
+			return nil, err
+		}
+	}
+	if h.options.IncludeNumericSeverity {
+		if obj, err = obj.set(h.options.NumericSeverityKey, int(severity)); err != nil {
+			return nil, err
+		}
+	}
+	if h.options.SortKeys {
+		obj = obj.sortKeys()
+	}
+	return obj.appendTo(buf), nil
+}
@@ -1,18 +1,39 @@
 package slogxgooglecloudlogging_test
 
-// TODO: implement testing and benchmarks
-
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/apiv2/loggingpb"
 	"go.innotegrity.dev/errorx"
 	"go.innotegrity.dev/slogx"
 	slogxgooglecloudlogging "go.innotegrity.dev/slogx-googlecloudlogging"
+	"go.innotegrity.dev/slogx/formatter"
+	"google.golang.org/api/option"
+	"google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 func TestGoogleCloudLogging1(t *testing.T) {
@@ -97,6 +118,3623 @@ func TestGoogleCloudLogging1(t *testing.T) {
 
 }
 
+func TestNewGoogleCloudLoggingHandlerNoStdout(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %s", err.Error())
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	debugMessages := []string{}
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		ClientOptions: []option.ClientOption{option.WithoutAuthentication()},
+		DebugLogger:   func(msg string) { debugMessages = append(debugMessages, msg) },
+		LogName:       "slogx-test",
+		ProjectID:     "slogx-test-project",
+	})
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	defer handler.Shutdown(true)
+
+	output, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read captured stdout: %s", err.Error())
+	}
+	if len(output) > 0 {
+		t.Errorf("expected no output on stdout during construction, got: %q", string(output))
+	}
+	if len(debugMessages) == 0 {
+		t.Errorf("expected diagnostic messages to be sent to DebugLogger")
+	}
+}
+
+func TestGoogleCloudLoggingHandlerConcurrentHandle(t *testing.T) {
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		ClientOptions:   []option.ClientOption{option.WithoutAuthentication()},
+		EnableAsync:     true,
+		LogName:         "slogx-test",
+		ProjectID:       "slogx-test-project",
+		RecordFormatter: &errFormatter{},
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	logger := slog.New(handler)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				logger.Info("concurrent message", slog.Int("goroutine", i), slog.Int("iteration", j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	handler.Shutdown(true)
+}
+
+func TestGoogleCloudLoggingHandlerReapFutures(t *testing.T) {
+	errCount := 0
+	var errLock sync.Mutex
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(&discardWriter{}, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		EnableAsync:         true,
+		LogName:             "slogx-test",
+		MaxConcurrentWrites: 100,
+		OnError: func(error, slog.Record) {
+			errLock.Lock()
+			errCount++
+			errLock.Unlock()
+		},
+		ProjectID:       "slogx-test-project",
+		RecordFormatter: &errFormatter{},
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	lines := 1000000
+	if testing.Short() {
+		lines = 10000
+	}
+	logger := slog.New(handler)
+	maxPending := 0
+	for i := 0; i < lines; i++ {
+		logger.Info("async message")
+		if pending := handler.PendingFutures(); pending > maxPending {
+			maxPending = pending
+		}
+	}
+	handler.Shutdown(true)
+
+	// with at most 100 writes in-flight, reaping every 100 futures should keep the slice small
+	if maxPending > 300 {
+		t.Errorf("expected the number of pending futures to remain bounded, got a maximum of %d", maxPending)
+	}
+	if errCount != lines {
+		t.Errorf("expected OnError to be called %d times, got %d", lines, errCount)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerShutdownErrors(t *testing.T) {
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		ClientOptions:   []option.ClientOption{option.WithoutAuthentication()},
+		EnableAsync:     true,
+		LogName:         "slogx-test",
+		ProjectID:       "slogx-test-project",
+		RecordFormatter: &errFormatter{},
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	logger := slog.New(handler)
+	logger.Info("first message")
+	logger.Info("second message")
+
+	err = handler.Shutdown(true)
+	if !errors.Is(err, errFormatting) {
+		t.Fatalf("expected Shutdown to return the formatting error, got: %v", err)
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); !ok || len(joined.Unwrap()) != 2 {
+		t.Errorf("expected Shutdown to return 2 combined errors, got: %v", err)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerSkipOnCanceledContext(t *testing.T) {
+	var reported error
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		EnableAsync: true,
+		LogName:     "slogx-test",
+		OnError: func(err error, _ slog.Record) {
+			reported = err
+		},
+		ProjectID:             "slogx-test-project",
+		SkipOnCanceledContext: true,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slog.New(handler).InfoContext(ctx, "canceled request")
+
+	if err := handler.Shutdown(true); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Shutdown to return the context error, got: %v", err)
+	}
+	if !errors.Is(reported, context.Canceled) {
+		t.Errorf("expected OnError to receive the context error, got: %v", reported)
+	}
+	if entries := w.Entries(); len(entries) != 0 {
+		t.Errorf("expected no entries to be written, got %d", len(entries))
+	}
+}
+
+func TestGoogleCloudLoggingHandlerShutdownIdempotent(t *testing.T) {
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		ClientOptions: []option.ClientOption{option.WithoutAuthentication()},
+		LogName:       "slogx-test",
+		ProjectID:     "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	derived := handler.WithGroup("a").(*slogxgooglecloudlogging.GoogleCloudLoggingHandler)
+	if err := handler.Shutdown(true); err != nil {
+		t.Fatalf("failed to shut down handler: %s", err.Error())
+	}
+	if err := handler.Shutdown(true); err != nil {
+		t.Errorf("expected second Shutdown to return nil, got: %s", err.Error())
+	}
+	if err := derived.Shutdown(true); err != nil {
+		t.Errorf("expected Shutdown of derived handler to return nil, got: %s", err.Error())
+	}
+	if refs := handler.ClientRefs(); refs != 0 {
+		t.Errorf("expected client to be released exactly once, got %d references", refs)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerShutdownConcurrentHandle(t *testing.T) {
+	handler, writer := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		EnableAsync: true,
+		LogName:     "slogx-test",
+		ProjectID:   "slogx-test-project",
+	})
+
+	var accepted atomic.Int32
+	var first sync.Once
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				err := handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "racing", 0))
+				switch {
+				case err == nil:
+					accepted.Add(1)
+					first.Do(func() { close(started) })
+				case errors.Is(err, slogxgooglecloudlogging.ErrHandlerShutdown):
+					return
+				default:
+					t.Errorf("unexpected error from Handle: %s", err.Error())
+					return
+				}
+			}
+		}()
+	}
+	<-started
+	if err := handler.Shutdown(true); err != nil {
+		t.Fatalf("failed to shut down handler: %s", err.Error())
+	}
+	// every record accepted before Shutdown must have been written by the time it returns
+	written := len(writer.Entries())
+	wg.Wait()
+	if written != int(accepted.Load()) {
+		t.Errorf("expected %d accepted records to be written before Shutdown returned, got %d", accepted.Load(), written)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerShutdownWaitingForWriteSlot(t *testing.T) {
+	writer := &blockingWriter{release: make(chan struct{})}
+	checks := &checkedFormatter{checked: make(chan struct{}, 2)}
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(writer, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		EnableAsync:         true,
+		LogName:             "slogx-test",
+		MaxConcurrentWrites: 1,
+		ProjectID:           "slogx-test-project",
+		RecordFormatter:     checks,
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	if err := handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "first", 0)); err != nil {
+		t.Fatalf("failed to handle the first record: %s", err.Error())
+	}
+	<-checks.checked
+
+	// the second record passes the shut down check and then waits for the first write to free its slot
+	waiting := make(chan error, 1)
+	go func() {
+		waiting <- handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "second", 0))
+	}()
+	<-checks.checked
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- handler.Shutdown(true)
+	}()
+	for !handler.FuturesClosed() {
+		runtime.Gosched()
+	}
+	close(writer.release)
+
+	if err := <-shutdown; err != nil {
+		t.Fatalf("failed to shut down handler: %s", err.Error())
+	}
+	if err := <-waiting; !errors.Is(err, slogxgooglecloudlogging.ErrHandlerShutdown) {
+		t.Errorf("expected ErrHandlerShutdown for a record which started waiting before Shutdown, got: %v", err)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerDropOnMaxConcurrentWrites(t *testing.T) {
+	writer := &blockingWriter{release: make(chan struct{})}
+	var dropped []string
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(writer, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		DropOnMaxConcurrentWrites: true,
+		EnableAsync:               true,
+		LogName:                   "slogx-test",
+		MaxConcurrentWrites:       1,
+		OnError: func(err error, r slog.Record) {
+			if errors.Is(err, slogxgooglecloudlogging.ErrWriteDropped) {
+				dropped = append(dropped, r.Message)
+			}
+		},
+		ProjectID: "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	if err := handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "in flight", 0)); err != nil {
+		t.Fatalf("failed to handle the first record: %s", err.Error())
+	}
+
+	// the first write holds the only slot, so the second record must be dropped rather than waiting for it
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "dropped", 0))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected the dropped record not to return an error, got: %s", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the second record to be dropped instead of blocking")
+	}
+	if !slices.Equal(dropped, []string{"dropped"}) {
+		t.Errorf("expected the dropped record to be reported to OnError, got: %v", dropped)
+	}
+
+	close(writer.release)
+	if err := handler.Shutdown(true); err != nil {
+		t.Errorf("failed to shut down handler: %s", err.Error())
+	}
+}
+
+func TestGoogleCloudLoggingHandlerFallbackWriter(t *testing.T) {
+	var fallback bytes.Buffer
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(&failingWriter{}, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		FallbackWriter: &fallback,
+		Labels:         map[string]string{"service": "checkout"},
+		LogName:        "slogx-test",
+		ProjectID:      "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	slog.New(handler).Warn("unreachable", slog.String("user", "jdoe"))
+
+	line := map[string]any{}
+	if err := json.Unmarshal(fallback.Bytes(), &line); err != nil {
+		t.Fatalf("expected fallback line to be valid JSON, got %q: %s", fallback.String(), err.Error())
+	}
+	if line["message"] != "unreachable" || line["user"] != "jdoe" {
+		t.Errorf("expected fallback line to contain the payload, got: %v", line)
+	}
+	if line["severity"] != logging.Warning.String() {
+		t.Errorf("expected fallback line to contain the severity, got: %v", line["severity"])
+	}
+	if line["fallbackReason"] != errWriting.Error() {
+		t.Errorf("expected fallback line to contain the failure reason, got: %v", line["fallbackReason"])
+	}
+	labels, _ := line["logging.googleapis.com/labels"].(map[string]any)
+	if labels["service"] != "checkout" {
+		t.Errorf("expected fallback line to contain the labels, got: %v", line)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerSharedFutures(t *testing.T) {
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(&discardWriter{}, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		EnableAsync:     true,
+		LogName:         "slogx-test",
+		ProjectID:       "slogx-test-project",
+		RecordFormatter: &errFormatter{},
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	child := handler.WithAttrs([]slog.Attr{slog.String("k", "v")})
+	slog.New(child).Info("from child")
+	slog.New(child.WithGroup("g")).Info("from grandchild")
+	slog.New(handler).Info("from parent")
+
+	err = handler.Shutdown(true)
+	if joined, ok := err.(interface{ Unwrap() []error }); !ok || len(joined.Unwrap()) != 3 {
+		t.Errorf("expected Shutdown to wait on the writes of all derived handlers, got: %v", err)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerDeriveTraceFromOTel(t *testing.T) {
+	opts := slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		DeriveTraceFromOTel: true,
+		LogName:             "slogx-test",
+		ProjectID:           "slogx-test-project",
+	}
+	restore := slogxgooglecloudlogging.SetOTelSpanContext(nil)
+	if _, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(&memoryWriter{}, opts); err == nil {
+		t.Error("expected an error when OpenTelemetry support is not built in")
+	}
+	restore()
+
+	defer slogxgooglecloudlogging.SetOTelSpanContext(func(ctx context.Context) (string, string, bool) {
+		if ctx.Value(spanContextKey{}) == nil {
+			return "", "", false
+		}
+		return "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true
+	})()
+	handler, writer := newTestHandler(t, opts)
+	logger := slog.New(handler)
+	logger.InfoContext(context.WithValue(context.Background(), spanContextKey{}, true), "with span")
+	logger.Info("without span")
+	entries := writer.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Trace != "projects/slogx-test-project/traces/4bf92f3577b34da6a3ce929d0e0e4736" ||
+		entries[0].SpanID != "00f067aa0ba902b7" || !entries[0].TraceSampled {
+		t.Errorf("expected trace to be taken from the span, got: %q %q %t", entries[0].Trace, entries[0].SpanID,
+			entries[0].TraceSampled)
+	}
+	if entries[1].Trace != "" || entries[1].SpanID != "" {
+		t.Errorf("expected no trace without a span, got: %q %q", entries[1].Trace, entries[1].SpanID)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerTraceExtractor(t *testing.T) {
+	defer slogxgooglecloudlogging.SetOTelSpanContext(func(ctx context.Context) (string, string, bool) {
+		if ctx.Value(spanContextKey{}) == nil {
+			return "", "", false
+		}
+		return "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", false
+	})()
+	handler, writer := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		DeriveTraceFromOTel: true,
+		LogName:             "slogx-test",
+		ProjectID:           "slogx-test-project",
+		TraceExtractor: func(ctx context.Context) (string, string, bool) {
+			ids, _ := ctx.Value(traceKey{}).([2]string)
+			return ids[0], ids[1], ids[0] != ""
+		},
+	})
+	withTrace := func(traceID, spanID string) context.Context {
+		return context.WithValue(context.Background(), traceKey{}, [2]string{traceID, spanID})
+	}
+	logger := slog.New(handler)
+	logger.InfoContext(withTrace("abc123", "def456"), "bare trace ID")
+	logger.InfoContext(withTrace("projects/other-project/traces/abc123", "def456"), "full trace name")
+	logger.InfoContext(withTrace("", "def456"), "span without a trace")
+	withSpan := context.WithValue(withTrace("abc123", "def456"), spanContextKey{}, true)
+	logger.InfoContext(withSpan, "trace and OpenTelemetry span")
+	withSpan = context.WithValue(withTrace("", "def456"), spanContextKey{}, true)
+	logger.InfoContext(withSpan, "OpenTelemetry span only")
+
+	entries := writer.Entries()
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 entries, got %d", len(entries))
+	}
+	tests := []struct {
+		trace   string
+		spanID  string
+		sampled bool
+	}{
+		{"projects/slogx-test-project/traces/abc123", "def456", true},
+		{"projects/other-project/traces/abc123", "def456", true},
+		{"", "", false},
+		{"projects/slogx-test-project/traces/abc123", "def456", true},
+		{"projects/slogx-test-project/traces/4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", false},
+	}
+	for i, tt := range tests {
+		if entries[i].Trace != tt.trace || entries[i].SpanID != tt.spanID || entries[i].TraceSampled != tt.sampled {
+			t.Errorf("entry %d: expected trace %q %q %t, got: %q %q %t", i, tt.trace, tt.spanID, tt.sampled,
+				entries[i].Trace, entries[i].SpanID, entries[i].TraceSampled)
+		}
+	}
+}
+
+func TestGoogleCloudLoggingHandlerSharedLifecycle(t *testing.T) {
+	handler, writer := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	child := handler.WithAttrs([]slog.Attr{slog.String("k", "v")})
+	sibling := handler.WithGroup("g")
+	if err := sibling.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "before", 0)); err != nil {
+		t.Fatalf("failed to handle record before shutdown: %s", err.Error())
+	}
+
+	if err := child.(*slogxgooglecloudlogging.GoogleCloudLoggingHandler).Shutdown(true); err != nil {
+		t.Fatalf("failed to shut down derived handler: %s", err.Error())
+	}
+	for _, h := range []slog.Handler{handler, child, sibling} {
+		err := h.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "after", 0))
+		if !errors.Is(err, slogxgooglecloudlogging.ErrHandlerShutdown) {
+			t.Errorf("expected ErrHandlerShutdown after a derived handler was shut down, got: %v", err)
+		}
+	}
+	if err := handler.Shutdown(true); err != nil {
+		t.Errorf("expected repeated Shutdown through the parent to return nil, got: %s", err.Error())
+	}
+	if entries := writer.Entries(); len(entries) != 1 {
+		t.Errorf("expected only the record logged before shutdown to be written, got %d entries", len(entries))
+	}
+	if _, err := handler.Clone(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test-clone",
+		ProjectID: "slogx-test-project",
+	}); !errors.Is(err, slogxgooglecloudlogging.ErrHandlerShutdown) {
+		t.Errorf("expected Clone to return ErrHandlerShutdown after shutdown, got: %v", err)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerShutdownTimeout(t *testing.T) {
+	writer := &blockingWriter{release: make(chan struct{})}
+	defer close(writer.release)
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(writer,
+		slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+			EnableAsync:     true,
+			LogName:         "slogx-test",
+			ProjectID:       "slogx-test-project",
+			ShutdownTimeout: 50 * time.Millisecond,
+		})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	logger := slog.New(handler)
+	logger.Info("first message")
+	logger.Info("second message")
+
+	start := time.Now()
+	err = handler.Shutdown(true)
+	if !errors.Is(err, slogxgooglecloudlogging.ErrShutdownTimeout) {
+		t.Fatalf("expected Shutdown to return a timeout error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "2 writes still pending") {
+		t.Errorf("expected timeout error to report 2 pending writes, got: %s", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Shutdown to return shortly after the timeout, took %s", elapsed)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerWriteTimeout(t *testing.T) {
+	writer := &blockingWriter{release: make(chan struct{})}
+	errs := make(chan error, 1)
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(writer,
+		slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+			EnableAsync: true,
+			LogName:     "slogx-test",
+			OnError: func(err error, _ slog.Record) {
+				errs <- err
+			},
+			ProjectID:    "slogx-test-project",
+			WriteTimeout: 50 * time.Millisecond,
+		})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	start := time.Now()
+	slog.New(handler).Info("never written")
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected a deadline exceeded error, got: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expected the write to be abandoned shortly after the timeout, took %s", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the write to time out and be reported to OnError")
+	}
+	close(writer.release)
+	if err := handler.Shutdown(true); err == nil {
+		t.Errorf("expected Shutdown to return the write error")
+	}
+}
+
+func TestGoogleCloudLoggingHandlerFlush(t *testing.T) {
+	handler, writer := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		EnableAsync: true,
+		LogName:     "slogx-test",
+		ProjectID:   "slogx-test-project",
+	})
+	defer handler.Shutdown(true)
+	logger := slog.New(handler)
+	for i := 0; i < 10; i++ {
+		logger.Info("before flush", slog.Int("index", i))
+	}
+
+	if err := handler.Flush(); err != nil {
+		t.Fatalf("failed to flush handler: %s", err.Error())
+	}
+	if entries := writer.Entries(); len(entries) != 10 {
+		t.Errorf("expected Flush to wait for 10 pending entries, got %d", len(entries))
+	}
+	if flushes := writer.Flushes(); flushes != 1 {
+		t.Errorf("expected Flush to flush the writer once, got %d flushes", flushes)
+	}
+	if pending := handler.PendingFutures(); pending != 0 {
+		t.Errorf("expected Flush to clear the pending futures, got %d", pending)
+	}
+
+	// flushing again is safe and the handler keeps accepting records
+	if err := handler.Flush(); err != nil {
+		t.Fatalf("failed to flush handler again: %s", err.Error())
+	}
+	logger.Info("after flush")
+	if err := handler.Flush(); err != nil {
+		t.Fatalf("failed to flush handler after logging: %s", err.Error())
+	}
+	if entries := writer.Entries(); len(entries) != 11 {
+		t.Errorf("expected the handler to keep writing entries after Flush, got %d", len(entries))
+	}
+
+	// errors from pending writes are returned by Flush
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(&failingWriter{}, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		EnableAsync: true,
+		LogName:     "slogx-test",
+		ProjectID:   "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	defer handler.Shutdown(true)
+	slog.New(handler).Info("failed write")
+	if err := handler.Flush(); !errors.Is(err, errWriting) {
+		t.Errorf("expected Flush to return the write error, got: %v", err)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerOnError(t *testing.T) {
+	type failure struct {
+		err     error
+		message string
+	}
+	failures := make(chan failure, 1)
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(&failingWriter{}, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		EnableAsync: true,
+		LogName:     "slogx-test",
+		OnError: func(err error, r slog.Record) {
+			failures <- failure{err: err, message: r.Message}
+		},
+		ProjectID: "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	slog.New(handler).Info("failed write")
+
+	select {
+	case f := <-failures:
+		if !errors.Is(f.err, errWriting) || f.message != "failed write" {
+			t.Errorf("expected OnError to receive the write error and record, got: %v %q", f.err, f.message)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the write error to be reported to OnError")
+	}
+	if err := handler.Shutdown(true); !errors.Is(err, errWriting) {
+		t.Errorf("expected Shutdown to return the write error, got: %v", err)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerUseBufferedLogging(t *testing.T) {
+	handler, writer := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:            "slogx-test",
+		ProjectID:          "slogx-test-project",
+		UseBufferedLogging: true,
+	})
+	logger := slog.New(handler)
+	logger.Info("first")
+	logger.Info("second")
+	if entries := writer.Entries(); len(entries) != 2 || writer.Syncs() != 0 {
+		t.Fatalf("expected 2 entries to be buffered without synchronous writes, got %d entries and %d sync writes",
+			len(entries), writer.Syncs())
+	}
+
+	if err := handler.Flush(); err != nil {
+		t.Fatalf("failed to flush handler: %s", err.Error())
+	}
+	if flushes := writer.Flushes(); flushes != 1 {
+		t.Errorf("expected Flush to flush the buffered entries, got %d flushes", flushes)
+	}
+	logger.Info("third")
+	if err := handler.Shutdown(true); err != nil {
+		t.Fatalf("failed to shut down handler: %s", err.Error())
+	}
+	if flushes := writer.Flushes(); flushes != 2 {
+		t.Errorf("expected Shutdown to flush the buffered entries, got %d flushes", flushes)
+	}
+	if entries := writer.Entries(); len(entries) != 3 || writer.Syncs() != 0 {
+		t.Errorf("expected 3 entries to be buffered without synchronous writes, got %d entries and %d sync writes",
+			len(entries), writer.Syncs())
+	}
+}
+
+func TestGoogleCloudLoggingHandlerWriteCallbacks(t *testing.T) {
+	type outcome struct {
+		failures   []error
+		severities []logging.Severity
+		successes  []int
+	}
+	newHandler := func(writer slogxgooglecloudlogging.EntryWriter, o *outcome) *slogxgooglecloudlogging.GoogleCloudLoggingHandler {
+		handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(writer, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+			LogName: "slogx-test",
+			OnWriteFailure: func(err error, _ time.Duration, severity logging.Severity) {
+				o.failures = append(o.failures, err)
+				o.severities = append(o.severities, severity)
+			},
+			OnWriteSuccess: func(bytes int, _ time.Duration, severity logging.Severity) {
+				o.successes = append(o.successes, bytes)
+				o.severities = append(o.severities, severity)
+			},
+			ProjectID: "slogx-test-project",
+		})
+		if err != nil {
+			t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+		}
+		return handler
+	}
+
+	succeeded := &outcome{}
+	writer := &memoryWriter{}
+	logger := slog.New(newHandler(writer, succeeded))
+	logger.Info("first")
+	logger.Warn("second")
+	if len(succeeded.failures) != 0 || len(succeeded.successes) != 2 {
+		t.Fatalf("expected OnWriteSuccess to be called once per entry, got %d successes and %d failures",
+			len(succeeded.successes), len(succeeded.failures))
+	}
+	for i, entry := range writer.Entries() {
+		if size := len(entry.Payload.(json.RawMessage)); succeeded.successes[i] != size {
+			t.Errorf("expected OnWriteSuccess to be passed the payload size %d, got: %d", size, succeeded.successes[i])
+		}
+	}
+	if !slices.Equal(succeeded.severities, []logging.Severity{logging.Info, logging.Warning}) {
+		t.Errorf("expected OnWriteSuccess to be passed each entry's severity, got: %v", succeeded.severities)
+	}
+
+	failed := &outcome{}
+	logger = slog.New(newHandler(&failingWriter{}, failed))
+	logger.Error("first")
+	logger.Info("second")
+	if len(failed.successes) != 0 || len(failed.failures) != 2 {
+		t.Fatalf("expected OnWriteFailure to be called once per entry, got %d successes and %d failures",
+			len(failed.successes), len(failed.failures))
+	}
+	for _, err := range failed.failures {
+		if !errors.Is(err, errWriting) {
+			t.Errorf("expected OnWriteFailure to be passed the write error, got: %v", err)
+		}
+	}
+	if !slices.Equal(failed.severities, []logging.Severity{logging.Error, logging.Info}) {
+		t.Errorf("expected OnWriteFailure to be passed each entry's severity, got: %v", failed.severities)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	logger, shutdown, err := slogxgooglecloudlogging.NewLogger(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		ClientOptions: []option.ClientOption{option.WithoutAuthentication()},
+		LogName:       "slogx-test",
+		ProjectID:     "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to create logger: %s", err.Error())
+	}
+	if _, ok := logger.Handler().(*slogxgooglecloudlogging.GoogleCloudLoggingHandler); !ok {
+		t.Errorf("expected logger to use a Google Cloud Logging handler, got %T", logger.Handler())
+	}
+	if err := shutdown(); err != nil {
+		t.Errorf("failed to shut down logger: %s", err.Error())
+	}
+
+	if _, _, err := slogxgooglecloudlogging.NewLogger(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{}); err == nil {
+		t.Errorf("expected invalid options to return an error")
+	}
+}
+
+func TestGoogleCloudLoggingHandlerClone(t *testing.T) {
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		ClientOptions: []option.ClientOption{option.WithoutAuthentication()},
+		LogName:       "slogx-test",
+		ProjectID:     "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	clone, err := handler.Clone(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		Level:     slog.LevelDebug,
+		LogName:   "slogx-test-audit",
+		ProjectID: "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to clone Google Cloud Logging Handler: %s", err.Error())
+	}
+	if !clone.Enabled(context.Background(), slog.LevelDebug) {
+		t.Errorf("expected cloned handler to use its own options")
+	}
+	if refs := handler.ClientRefs(); refs != 2 {
+		t.Fatalf("expected client to be shared by 2 handlers, got %d", refs)
+	}
+	if err := clone.Shutdown(true); err != nil {
+		t.Fatalf("failed to shut down cloned handler: %s", err.Error())
+	}
+	if refs := handler.ClientRefs(); refs != 1 {
+		t.Errorf("expected client to remain open after shutting down the clone, got %d references", refs)
+	}
+	if err := handler.Shutdown(true); err != nil {
+		t.Fatalf("failed to shut down handler: %s", err.Error())
+	}
+	if refs := handler.ClientRefs(); refs != 0 {
+		t.Errorf("expected client to be released after shutting down all handlers, got %d references", refs)
+	}
+	if handler.RetainClient() || handler.ClientRefs() != 0 {
+		t.Errorf("expected a closed client not to be retained again, got %d references", handler.ClientRefs())
+	}
+
+	if _, err := handler.Clone(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{}); err == nil {
+		t.Errorf("expected invalid options to return an error")
+	}
+}
+
+func TestGoogleCloudLoggingHandlerCloneConcurrentShutdown(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+			ClientOptions: []option.ClientOption{option.WithoutAuthentication()},
+			LogName:       "slogx-test",
+			ProjectID:     "slogx-test-project",
+		})
+		if err != nil {
+			t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+		}
+
+		clones := make(chan *slogxgooglecloudlogging.GoogleCloudLoggingHandler, 1)
+		go func() {
+			clone, err := handler.Clone(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{LogName: "slogx-test-clone"})
+			if err != nil && !errors.Is(err, slogxgooglecloudlogging.ErrHandlerShutdown) {
+				t.Errorf("unexpected error from Clone: %s", err.Error())
+			}
+			clones <- clone
+		}()
+		if err := handler.Shutdown(true); err != nil {
+			t.Fatalf("failed to shut down handler: %s", err.Error())
+		}
+
+		// a clone created before Shutdown keeps the client open; one attempted afterwards must fail
+		clone := <-clones
+		if clone == nil {
+			if refs := handler.ClientRefs(); refs != 0 {
+				t.Errorf("expected the client to be released when Clone fails, got %d references", refs)
+			}
+			continue
+		}
+		if refs := handler.ClientRefs(); refs != 1 {
+			t.Errorf("expected the clone to hold the only reference to the client, got %d references", refs)
+		}
+		if err := clone.Shutdown(true); err != nil {
+			t.Errorf("failed to shut down cloned handler: %s", err.Error())
+		}
+	}
+}
+
+func TestGoogleCloudLoggingHandlerCloneWithWriter(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	clone, err := handler.Clone(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{LogName: "slogx-test-clone"})
+	if err != nil {
+		t.Fatalf("failed to clone Google Cloud Logging Handler: %s", err.Error())
+	}
+
+	// the writer belongs to the caller, so shutting down the parent leaves it usable by the clone
+	if err := handler.Shutdown(true); err != nil {
+		t.Fatalf("failed to shut down handler: %s", err.Error())
+	}
+	if err := clone.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "from the clone", 0)); err != nil {
+		t.Fatalf("expected the clone to keep writing after the parent was shut down, got: %s", err.Error())
+	}
+	if entries := w.Entries(); len(entries) != 1 || decodePayload(t, entries[0])["message"] != "from the clone" {
+		t.Errorf("expected the clone's entry to be written to the shared writer, got: %v", entries)
+	}
+	if err := clone.Shutdown(true); err != nil {
+		t.Errorf("failed to shut down cloned handler: %s", err.Error())
+	}
+}
+
+func TestGoogleCloudLoggingHandlerLogNameFunc(t *testing.T) {
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		ClientOptions: []option.ClientOption{option.WithoutAuthentication()},
+		LogName:       "slogx-test",
+		LogNameFunc: func(r slog.Record, _ []slog.Attr) string {
+			if r.Message == "audit" {
+				return "slogx-test-audit"
+			}
+			return ""
+		},
+		ProjectID: "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+
+	app := handler.EntryLogger(slog.NewRecord(time.Now(), slog.LevelInfo, "app", 0))
+	audit := handler.EntryLogger(slog.NewRecord(time.Now(), slog.LevelInfo, "audit", 0))
+	if app == audit {
+		t.Errorf("expected audit record to be routed to a different logger")
+	}
+	if handler.EntryLogger(slog.NewRecord(time.Now(), slog.LevelInfo, "audit", 0)) != audit {
+		t.Errorf("expected logger for the audit log to be reused")
+	}
+	if n := handler.CachedLoggers(); n != 2 {
+		t.Errorf("expected 2 loggers to be cached, got %d", n)
+	}
+	if err := handler.Shutdown(true); err != nil {
+		t.Fatalf("failed to shut down handler: %s", err.Error())
+	}
+	if n := handler.CachedLoggers(); n != 0 {
+		t.Errorf("expected cached loggers to be released on shutdown, got %d", n)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerDryRun(t *testing.T) {
+	var out bytes.Buffer
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		DryRun:       true,
+		DryRunWriter: &out,
+		HTTPRequestExtractor: func(_ context.Context, _ []slog.Attr) *logging.HTTPRequest {
+			req := httptest.NewRequest(http.MethodPost, "https://example.com/checkout", nil)
+			req.Header.Set("User-Agent", "slogx-test-agent")
+			req.Header.Set("Referer", "https://example.com/cart")
+			return &logging.HTTPRequest{
+				CacheHit:     true,
+				Latency:      time.Second,
+				LocalIP:      "10.0.0.1",
+				RemoteIP:     "192.0.2.1",
+				Request:      req,
+				RequestSize:  128,
+				ResponseSize: 256,
+				Status:       http.StatusCreated,
+			}
+		},
+		Labels:            map[string]string{"service": "checkout"},
+		LogName:           "slogx-test",
+		MonitoredResource: &monitoredres.MonitoredResource{Type: "k8s_pod", Labels: map[string]string{"pod_name": "web-1"}},
+		ProjectID:         "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	logger := slog.New(handler)
+	logger.Debug("filtered")
+	logger.Warn("dry run", slog.String("user", "jdoe"))
+	if err := handler.Shutdown(false); err != nil {
+		t.Fatalf("failed to shut down handler: %s", err.Error())
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d: %q", len(lines), out.String())
+	}
+	entry := map[string]any{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("expected entry to be valid JSON: %s", err.Error())
+	}
+	if entry["severity"] != logging.Warning.String() {
+		t.Errorf("expected entry to contain the severity, got: %v", entry)
+	}
+	labels, _ := entry["labels"].(map[string]any)
+	if labels["service"] != "checkout" {
+		t.Errorf("expected entry to contain the labels, got: %v", entry)
+	}
+	payload, _ := entry["payload"].(map[string]any)
+	if payload["message"] != "dry run" || payload["user"] != "jdoe" {
+		t.Errorf("expected entry to contain the formatted payload, got: %v", entry)
+	}
+	resource, _ := entry["resource"].(map[string]any)
+	resourceLabels, _ := resource["labels"].(map[string]any)
+	if resource["type"] != "k8s_pod" || resourceLabels["pod_name"] != "web-1" {
+		t.Errorf("expected entry to contain the monitored resource, got: %v", entry)
+	}
+	request, _ := entry["httpRequest"].(map[string]any)
+	expected := map[string]any{
+		"cacheHit":     true,
+		"latency":      "1s",
+		"localIp":      "10.0.0.1",
+		"method":       http.MethodPost,
+		"protocol":     "HTTP/1.1",
+		"referer":      "https://example.com/cart",
+		"remoteIp":     "192.0.2.1",
+		"requestSize":  float64(128),
+		"responseSize": float64(256),
+		"status":       float64(http.StatusCreated),
+		"url":          "https://example.com/checkout",
+		"userAgent":    "slogx-test-agent",
+	}
+	if !reflect.DeepEqual(request, expected) {
+		t.Errorf("expected entry to contain the full HTTP request, got: %v", request)
+	}
+}
+
+func TestNewGoogleCloudLoggingHandlerDetectProjectID(t *testing.T) {
+	defer slogxgooglecloudlogging.SetDetectProjectID(func() (string, error) {
+		return "detected-project", nil
+	})()
+	var out bytes.Buffer
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		DryRun:       true,
+		DryRunWriter: &out,
+		LogName:      "slogx-test",
+		TraceExtractor: func(context.Context) (string, string, bool) {
+			return "105445aa7843bc8bf206b12000100000", "", false
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	slog.New(handler).Info("detected")
+	if !strings.Contains(out.String(), "projects/detected-project/traces/") {
+		t.Errorf("expected the detected project ID to be used, got: %s", out.String())
+	}
+
+	clone, err := handler.Clone(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{LogName: "slogx-test-clone"})
+	if err != nil {
+		t.Fatalf("expected Clone to use the existing handler's project ID, got: %s", err.Error())
+	}
+	clone.Shutdown(true)
+	handler.Shutdown(true)
+
+	slogxgooglecloudlogging.SetDetectProjectID(func() (string, error) {
+		return "", errors.New("not running on GCP")
+	})
+	_, err = slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		DryRun:  true,
+		LogName: "slogx-test",
+	})
+	if err == nil || !strings.Contains(err.Error(), "not running on GCP") {
+		t.Errorf("expected an error when the project ID cannot be detected, got: %v", err)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerEndpoint(t *testing.T) {
+	fake := &fakeLoggingServer{}
+	addr := startFakeServer(t, fake)
+
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		Endpoint:              addr,
+		LogName:               "slogx-test",
+		ProjectID:             "slogx-test-project",
+		UseGlobalResource:     true,
+		WithoutAuthentication: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	defer handler.Shutdown(true)
+	if err := handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "to the fake", 0)); err != nil {
+		t.Fatalf("failed to write to the fake endpoint: %s", err.Error())
+	}
+	if !strings.Contains(strings.Join(fake.Messages(), "\n"), "to the fake") {
+		t.Errorf("expected the entry to be written to the fake endpoint, got: %v", fake.Messages())
+	}
+	resources := fake.Resources()
+	if len(resources) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(resources))
+	}
+	if resources[0].GetType() != "global" || resources[0].GetLabels()["project_id"] != "slogx-test-project" {
+		t.Errorf("expected UseGlobalResource to associate the entry with the global resource, got: %v", resources[0])
+	}
+}
+
+func TestNewGoogleCloudLoggingHandlerWithClient(t *testing.T) {
+	fake := &fakeLoggingServer{}
+	addr := startFakeServer(t, fake)
+
+	client, err := logging.NewClient(context.Background(), "slogx-test-project",
+		option.WithEndpoint(addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err.Error())
+	}
+	defer client.Close()
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithClient(client, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:           "slogx-test",
+		ProjectID:         "slogx-test-project",
+		UseGlobalResource: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	if err := handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "from the handler", 0)); err != nil {
+		t.Fatalf("failed to write through the handler: %s", err.Error())
+	}
+	if err := handler.Shutdown(true); err != nil {
+		t.Fatalf("failed to shut down handler: %s", err.Error())
+	}
+
+	// the caller owns the client, so it must still be usable after the handler has been shut down
+	if err := client.Logger("slogx-test").LogSync(context.Background(), logging.Entry{Payload: "after shutdown"}); err != nil {
+		t.Fatalf("expected the client to remain open after Shutdown, got: %s", err.Error())
+	}
+	if messages := fake.Messages(); !slices.Contains(messages, "from the handler") {
+		t.Errorf("expected the handler's entry to be written using the client, got: %v", messages)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerClientOnError(t *testing.T) {
+	fake := &fakeLoggingServer{err: status.Error(codes.PermissionDenied, "permission denied")}
+	addr := startFakeServer(t, fake)
+
+	newHandler := func(buffered bool, clientErrs chan<- error) *slogxgooglecloudlogging.GoogleCloudLoggingHandler {
+		handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+			ClientOnError: func(err error) {
+				select {
+				case clientErrs <- err:
+				default:
+				}
+			},
+			Endpoint:              addr,
+			LogName:               "slogx-test",
+			ProjectID:             "slogx-test-project",
+			UseBufferedLogging:    buffered,
+			UseGlobalResource:     true,
+			WithoutAuthentication: true,
+		})
+		if err != nil {
+			t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+		}
+		return handler
+	}
+
+	// synchronous failures are returned by Handle rather than reported to ClientOnError
+	clientErrs := make(chan error, 1)
+	handler := newHandler(false, clientErrs)
+	err := handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "synchronous", 0))
+	if !errors.Is(err, slogxgooglecloudlogging.ErrPermissionDenied) {
+		t.Errorf("expected Handle to return the write error, got: %v", err)
+	}
+	_ = handler.Shutdown(true)
+	select {
+	case err := <-clientErrs:
+		t.Errorf("expected synchronous failures not to be reported to ClientOnError, got: %s", err.Error())
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// buffered failures are reported to ClientOnError
+	handler = newHandler(true, clientErrs)
+	if err := handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "buffered", 0)); err != nil {
+		t.Fatalf("expected buffering the entry to succeed, got: %s", err.Error())
+	}
+	_ = handler.Flush()
+	select {
+	case err := <-clientErrs:
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("expected ClientOnError to be passed the write error, got: %s", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected buffered failures to be reported to ClientOnError")
+	}
+	_ = handler.Shutdown(true)
+}
+
+func TestGoogleCloudLoggingHandlerProjectRouter(t *testing.T) {
+	fake := &fakeLoggingServer{}
+	addr := startFakeServer(t, fake)
+
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		Endpoint:  addr,
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+		ProjectRouter: func(_ slog.Record, attrs []slog.Attr) string {
+			for _, a := range attrs {
+				if a.Key == "customer" {
+					return "slogx-" + a.Value.String()
+				}
+			}
+			return ""
+		},
+		TraceExtractor: func(context.Context) (string, string, bool) {
+			return "4bf92f3577b34da6a3ce929d0e0e4736", "", false
+		},
+		UseGlobalResource:     true,
+		WithoutAuthentication: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	logger := slog.New(handler)
+	logger.Info("for acme", slog.String("customer", "acme"))
+	logger.Info("for acme again", slog.String("customer", "acme"))
+	logger.Info("for globex", slog.String("customer", "globex"))
+	logger.Info("for the default project")
+	if projects := handler.CachedProjects(); projects != 2 {
+		t.Errorf("expected clients to be created for 2 projects, got %d", projects)
+	}
+	if err := handler.Shutdown(true); err != nil {
+		t.Fatalf("failed to shut down the handler: %s", err.Error())
+	}
+	if projects := handler.CachedProjects(); projects != 0 {
+		t.Errorf("expected the clients for other projects to be released on shutdown, got %d", projects)
+	}
+
+	expected := []string{
+		"projects/slogx-acme/logs/slogx-test",
+		"projects/slogx-acme/logs/slogx-test",
+		"projects/slogx-globex/logs/slogx-test",
+		"projects/slogx-test-project/logs/slogx-test",
+	}
+	if names := fake.LogNames(); !slices.Equal(names, expected) {
+		t.Errorf("expected entries to be written to logs %v, got %v", expected, names)
+	}
+
+	expected = []string{
+		"projects/slogx-acme/traces/4bf92f3577b34da6a3ce929d0e0e4736",
+		"projects/slogx-acme/traces/4bf92f3577b34da6a3ce929d0e0e4736",
+		"projects/slogx-globex/traces/4bf92f3577b34da6a3ce929d0e0e4736",
+		"projects/slogx-test-project/traces/4bf92f3577b34da6a3ce929d0e0e4736",
+	}
+	if traces := fake.Traces(); !slices.Equal(traces, expected) {
+		t.Errorf("expected traces to be qualified with the routed project %v, got %v", expected, traces)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerClientCreationJitter(t *testing.T) {
+	opts := slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		ClientCreationJitter:  10 * time.Millisecond,
+		LogName:               "slogx-test",
+		ProjectID:             "slogx-test-project",
+		UseGlobalResource:     true,
+		WithoutAuthentication: true,
+	}
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(opts)
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	handler.Shutdown(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts.ClientCreationJitter = time.Hour
+	if _, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithContext(ctx, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected waiting for the jitter to stop when the context is canceled, got: %v", err)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerLazyInit(t *testing.T) {
+	failing, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		ClientOptions:     []option.ClientOption{option.WithCredentialsFile("testdata/missing.json")},
+		LazyInit:          true,
+		LogName:           "slogx-test",
+		ProjectID:         "slogx-test-project",
+		UseGlobalResource: true,
+	})
+	if err != nil {
+		t.Fatalf("expected creating the client to be deferred, got: %s", err.Error())
+	}
+	first := failing.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "first", 0))
+	if first == nil {
+		t.Fatalf("expected an error creating the client on the first write")
+	}
+	second := failing.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "second", 0))
+	if second == nil || second.Error() != first.Error() {
+		t.Errorf("expected the error creating the client to be returned for later writes, got: %v", second)
+	}
+	if err := failing.Shutdown(true); err != nil {
+		t.Errorf("expected shutting down without a client to succeed, got: %s", err.Error())
+	}
+
+	fake := &fakeLoggingServer{}
+	addr := startFakeServer(t, fake)
+
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		Endpoint:              addr,
+		LazyInit:              true,
+		LogName:               "slogx-test",
+		ProjectID:             "slogx-test-project",
+		UseGlobalResource:     true,
+		WithoutAuthentication: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	clone, err := handler.Clone(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{LogName: "slogx-audit"})
+	if err != nil {
+		t.Fatalf("failed to clone Google Cloud Logging Handler: %s", err.Error())
+	}
+	slog.New(handler).With("component", "app").Info("from the handler")
+	slog.New(clone).Info("from the clone")
+	if err := handler.Shutdown(true); err != nil {
+		t.Errorf("failed to shut down the handler: %s", err.Error())
+	}
+	if err := clone.Shutdown(true); err != nil {
+		t.Errorf("failed to shut down the clone: %s", err.Error())
+	}
+
+	expected := []string{"projects/slogx-test-project/logs/slogx-test", "projects/slogx-test-project/logs/slogx-audit"}
+	if names := fake.LogNames(); !slices.Equal(names, expected) {
+		t.Errorf("expected entries to be written to logs %v, got %v", expected, names)
+	}
+}
+
+func TestNewGoogleCloudLoggingHandlerValidation(t *testing.T) {
+	defer slogxgooglecloudlogging.SetDetectProjectID(func() (string, error) {
+		return "", errors.New("not running on GCP")
+	})()
+	tests := []struct {
+		logName   string
+		projectID string
+	}{
+		{logName: "", projectID: "slogx-test-project"},
+		{logName: "slogx test", projectID: "slogx-test-project"},
+		{logName: "slogx%2Ftest", projectID: "slogx-test-project"},
+		{logName: "slogx-test", projectID: ""},
+		{logName: "slogx-test", projectID: "short"},
+		{logName: "slogx-test", projectID: "Slogx-Test-Project"},
+		{logName: "slogx-test", projectID: "1slogx-test-project"},
+		{logName: "slogx-test", projectID: "slogx-test-project-"},
+		{logName: "slogx-test", projectID: "slogx_test_project"},
+	}
+	for _, test := range tests {
+		_, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+			ClientOptions: []option.ClientOption{option.WithoutAuthentication()},
+			LogName:       test.logName,
+			ProjectID:     test.projectID,
+		})
+		if err == nil {
+			t.Errorf("expected an error for log name '%s' and project ID '%s'", test.logName, test.projectID)
+		}
+	}
+}
+
+func TestGoogleCloudLoggingHandlerDynamicLevel(t *testing.T) {
+	level := &slog.LevelVar{}
+	level.Set(slog.LevelInfo)
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		ClientOptions: []option.ClientOption{option.WithoutAuthentication()},
+		Level:         level,
+		LogName:       "slogx-test",
+		ProjectID:     "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	defer handler.Shutdown(true)
+
+	handlers := []slog.Handler{
+		handler,
+		handler.WithAttrs([]slog.Attr{slog.String("k1", "v1")}),
+		handler.WithGroup("group1"),
+		handler.WithGroup("group1").WithAttrs([]slog.Attr{slog.String("k2", "v2")}).WithGroup("group2"),
+	}
+	ctx := context.Background()
+	for i, h := range handlers {
+		if h.Enabled(ctx, slog.LevelDebug) {
+			t.Errorf("handler %d: expected debug level to be disabled", i)
+		}
+	}
+	level.Set(slog.LevelDebug)
+	for i, h := range handlers {
+		if !h.Enabled(ctx, slog.LevelDebug) {
+			t.Errorf("handler %d: expected debug level to be enabled after changing the level", i)
+		}
+	}
+	level.Set(slog.LevelError)
+	for i, h := range handlers {
+		if h.Enabled(ctx, slog.LevelWarn) {
+			t.Errorf("handler %d: expected warn level to be disabled after changing the level", i)
+		}
+	}
+}
+
+func TestGoogleCloudLoggingHandlerWithWriter(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LabelExtractor: func(_ context.Context, _ slog.Record, _ []slog.Attr) map[string]string {
+			return map[string]string{"env": "test"}
+		},
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	logger := slog.New(handler)
+	logger.Debug("this message should be filtered")
+	logger.Warn("this is a warning message", slog.String("attr", "value"))
+	if err := handler.Shutdown(true); err != nil {
+		t.Fatalf("failed to shutdown handler: %s", err.Error())
+	}
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.Severity != logging.Warning {
+		t.Errorf("expected severity %s, got %s", logging.Warning, entry.Severity)
+	}
+	if entry.Labels["env"] != "test" {
+		t.Errorf("expected label 'env' to be 'test', got: %v", entry.Labels)
+	}
+	payload := decodePayload(t, entry)
+	if payload["message"] != "this is a warning message" {
+		t.Errorf("expected message in payload, got: %v", payload)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerBatch(t *testing.T) {
+	opts := slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		BatchFlushInterval: 10 * time.Millisecond,
+		BatchSize:          2,
+		LogName:            "slogx-test",
+		ProjectID:          "slogx-test-project",
+	}
+	handler, w := newTestHandler(t, opts)
+	logger := slog.New(handler)
+	logger.Info("first message")
+	logger.Info("second message")
+	logger.Info("third message")
+	if entries := w.Entries(); len(entries) != 3 || w.Syncs() != 0 || w.Flushes() != 0 {
+		t.Fatalf("expected 3 entries to be buffered without synchronous writes or flushes, got %d entries, %d sync "+
+			"writes and %d flushes", len(entries), w.Syncs(), w.Flushes())
+	}
+	if err := handler.Shutdown(true); err != nil {
+		t.Fatalf("failed to shut down handler: %s", err.Error())
+	}
+	if flushes := w.Flushes(); flushes != 1 {
+		t.Errorf("expected Shutdown to flush the buffered entries, got %d flushes", flushes)
+	}
+
+	// the batch options are passed to the client's loggers in place of the matching thresholds
+	opts.DelayThreshold = time.Second
+	opts.EntryCountThreshold = 500
+	loggerOpts := slogxgooglecloudlogging.BuildLoggerOptions(opts)
+	expected := []logging.LoggerOption{
+		logging.DelayThreshold(10 * time.Millisecond),
+		logging.EntryCountThreshold(2),
+	}
+	if !reflect.DeepEqual(loggerOpts, expected) {
+		t.Errorf("expected the batch options to set the logger thresholds, got: %v", loggerOpts)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerPromoteOnErrorAttr(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		ErrorAttrKey:       "failure",
+		LogName:            "slogx-test",
+		ProjectID:          "slogx-test-project",
+		PromoteOnErrorAttr: true,
+	})
+	logger := slog.New(handler)
+	logger.Info("no error", slog.Any("failure", nil))
+	logger.Info("has error", slog.Any("failure", errors.New("some error")))
+	logger.Info("other key", slog.Any("error", errors.New("some error")))
+	logger.Log(context.Background(), slog.Level(slogx.LevelFatal), "already severe", slog.Any("failure", errors.New("x")))
+
+	expected := []logging.Severity{logging.Info, logging.Error, logging.Info, logging.Critical}
+	entries := w.Entries()
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries to be written, got %d", len(expected), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Severity != expected[i] {
+			t.Errorf("entry %d: expected severity %s, got %s", i, expected[i], entry.Severity)
+		}
+	}
+}
+
+func TestGoogleCloudLoggingHandlerNoAttrs(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	slog.New(handler).Info("done")
+	slog.New(handler.WithGroup("a")).Info("done in group")
+	if err := handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "done without pc", 0)); err != nil {
+		t.Fatalf("expected record without attributes to be handled, got: %s", err.Error())
+	}
+
+	entries := w.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries to be written, got %d", len(entries))
+	}
+	for i, message := range []string{"done", "done in group", "done without pc"} {
+		raw, ok := entries[i].Payload.(json.RawMessage)
+		if !ok || !json.Valid(raw) {
+			t.Fatalf("expected entry %d to have a valid JSON payload, got: %v", i, entries[i].Payload)
+		}
+		if payload := decodePayload(t, entries[i]); payload["message"] != message {
+			t.Errorf("expected payload of entry %d to contain the message %q, got: %v", i, message, payload)
+		}
+	}
+}
+
+func TestGoogleCloudLoggingHandlerEmptyWithAttrsAndGroup(t *testing.T) {
+	handler, _ := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	for i, h := range []slog.Handler{
+		handler.WithAttrs(nil),
+		handler.WithAttrs([]slog.Attr{}),
+		handler.WithAttrs([]slog.Attr{slog.Group("empty")}),
+		handler.WithGroup(""),
+	} {
+		if h != slog.Handler(handler) {
+			t.Errorf("expected call %d to return the existing handler", i)
+		}
+	}
+	if allocs := testing.AllocsPerRun(100, func() { handler.WithAttrs(nil).WithGroup("") }); allocs != 0 {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerAutoFlushInterval(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		AutoFlushInterval: 10 * time.Millisecond,
+		EnableAsync:       true,
+		LogName:           "slogx-test",
+		ProjectID:         "slogx-test-project",
+	})
+	slog.New(handler).Info("flushed in the background")
+
+	deadline := time.Now().Add(time.Second)
+	for (w.Flushes() == 0 || handler.PendingFutures() > 0) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if w.Flushes() == 0 {
+		t.Error("expected the logger to be flushed in the background")
+	}
+	if pending := handler.PendingFutures(); pending != 0 {
+		t.Errorf("expected completed writes to be removed in the background, got %d pending", pending)
+	}
+
+	if err := handler.Shutdown(true); err != nil {
+		t.Fatalf("failed to shut down handler: %s", err.Error())
+	}
+	flushes := w.Flushes()
+	time.Sleep(50 * time.Millisecond)
+	if w.Flushes() != flushes {
+		t.Errorf("expected background flushing to stop on shutdown, got %d more flushes", w.Flushes()-flushes)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerIncludeTimestampInPayload(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		IncludeTimestampInPayload: true,
+		LogName:                   "slogx-test",
+		ProjectID:                 "slogx-test-project",
+		TimestampLocation:         time.UTC,
+		TimestampPayloadKey:       "timestamp",
+	})
+	now := time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.FixedZone("EST", -5*60*60))
+	if err := handler.Handle(context.Background(), slog.NewRecord(now, slog.LevelInfo, "timestamped", 0)); err != nil {
+		t.Fatalf("failed to handle record: %s", err.Error())
+	}
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	payload := decodePayload(t, entries[0])
+	if payload["timestamp"] != "2024-03-01T17:30:45.123456789Z" {
+		t.Errorf("expected payload to contain the record's time, got: %v", payload["timestamp"])
+	}
+}
+
+func TestGoogleCloudLoggingHandlerTimestampLocation(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.UTC)
+	tests := []struct {
+		name     string
+		location *time.Location
+		expected string
+	}{
+		{"converted", time.FixedZone("IST", 5*60*60+30*60), "2024-03-01T18:00:45.123456789+05:30"},
+		{"unchanged", nil, "2024-03-01T12:30:45.123456789Z"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+				IncludeTimestampInPayload: true,
+				LogName:                   "slogx-test",
+				ProjectID:                 "slogx-test-project",
+				TimestampLocation:         test.location,
+			})
+			if err := handler.Handle(context.Background(), slog.NewRecord(now, slog.LevelInfo, "located", 0)); err != nil {
+				t.Fatalf("failed to handle record: %s", err.Error())
+			}
+
+			entries := w.Entries()
+			if len(entries) != 1 {
+				t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+			}
+			if ts := entries[0].Timestamp; !ts.Equal(now) || ts.Format(time.RFC3339Nano) != test.expected {
+				t.Errorf("expected entry timestamp %s, got %s", test.expected, ts.Format(time.RFC3339Nano))
+			}
+			payload := decodePayload(t, entries[0])
+			if payload["time"] != test.expected {
+				t.Errorf("expected payload timestamp %s, got: %v", test.expected, payload["time"])
+			}
+		})
+	}
+}
+
+func TestGoogleCloudLoggingHandlerTimestampPrecision(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		IncludeTimestampInPayload: true,
+		LogName:                   "slogx-test",
+		ProjectID:                 "slogx-test-project",
+		TimestampLocation:         time.UTC,
+		TimestampPrecision:        time.Millisecond,
+	})
+	now := time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.UTC)
+	if err := handler.Handle(context.Background(), slog.NewRecord(now, slog.LevelInfo, "truncated", 0)); err != nil {
+		t.Fatalf("failed to handle record: %s", err.Error())
+	}
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	if want := time.Date(2024, 3, 1, 12, 30, 45, 123000000, time.UTC); !entries[0].Timestamp.Equal(want) {
+		t.Errorf("expected entry timestamp to be truncated to %s, got %s", want, entries[0].Timestamp)
+	}
+	payload := decodePayload(t, entries[0])
+	if payload["time"] != "2024-03-01T12:30:45.123Z" {
+		t.Errorf("expected payload timestamp to be truncated, got: %v", payload["time"])
+	}
+}
+
+func TestGoogleCloudLoggingHandlerZeroTime(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	before := time.Now()
+	if err := handler.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "zero time", 0)); err != nil {
+		t.Fatalf("failed to handle record: %s", err.Error())
+	}
+	preserving, err := handler.Clone(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:          "slogx-test",
+		PreserveZeroTime: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to clone Google Cloud Logging Handler: %s", err.Error())
+	}
+	if err := preserving.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "zero time", 0)); err != nil {
+		t.Fatalf("failed to handle record: %s", err.Error())
+	}
+
+	entries := w.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries to be written, got %d", len(entries))
+	}
+	if ts := entries[0].Timestamp; ts.Before(before) || ts.After(time.Now()) {
+		t.Errorf("expected a zero record time to be replaced with the current time, got %s", ts)
+	}
+	if ts := entries[1].Timestamp; !ts.IsZero() {
+		t.Errorf("expected a zero record time to be preserved, got %s", ts)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerTypedLoggerOptions(t *testing.T) {
+	opts := slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LoggerOptions: []logging.LoggerOption{logging.EntryCountThreshold(10)},
+	}
+	if n := len(slogxgooglecloudlogging.BuildLoggerOptions(opts)); n != 1 {
+		t.Errorf("expected only the explicit logger option without typed options, got %d", n)
+	}
+
+	opts.CommonLabels = map[string]string{"env": "test"}
+	opts.ConcurrentWriteLimit = 4
+	opts.DelayThreshold = 2 * time.Second
+	opts.EntryByteThreshold = 1 << 20
+	opts.EntryCountThreshold = 500
+	loggerOpts := slogxgooglecloudlogging.BuildLoggerOptions(opts)
+	if len(loggerOpts) != 6 {
+		t.Fatalf("expected 6 logger options, got %d", len(loggerOpts))
+	}
+	if !reflect.DeepEqual(loggerOpts[5], logging.EntryCountThreshold(500)) {
+		t.Errorf("expected typed options to be applied after the explicit logger options")
+	}
+
+	opts.PartialSuccess = true
+	loggerOpts = slogxgooglecloudlogging.BuildLoggerOptions(opts)
+	if len(loggerOpts) != 7 || !reflect.DeepEqual(loggerOpts[6], logging.PartialSuccess()) {
+		t.Errorf("expected the partial success logger option to be applied")
+	}
+}
+
+func TestGoogleCloudLoggingHandlerDedupeMessage(t *testing.T) {
+	tests := []struct {
+		formatter  formatter.BufferFormatter
+		messageKey string
+		expected   string
+	}{
+		{
+			formatter: &staticFormatter{payload: `{"msg":"hello","attrs":{"message":"hello","msg":"other","n":1}}`},
+			expected:  `{"message":"hello","attrs":{"msg":"other","n":1}}`,
+		},
+		{
+			formatter:  &staticFormatter{payload: `{"message":"hello","data":{"message":"hello"},"msg":"hello"}`},
+			messageKey: "summary",
+			expected:   `{"summary":"hello","data":{}}`,
+		},
+	}
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		DedupeMessage: true,
+		LogName:       "slogx-test",
+		ProjectID:     "slogx-test-project",
+	})
+	slog.New(handler).Info("hello", slog.String("msg", "hello"), slog.Group("g", slog.String("message", "hello")))
+	payload := decodePayload(t, w.Entries()[0])
+	if _, ok := payload["msg"]; ok || payload["message"] != "hello" {
+		t.Errorf("expected the message to only appear under the message key, got: %v", payload)
+	}
+	if group, _ := payload["g"].(map[string]any); len(group) != 0 {
+		t.Errorf("expected the nested copy of the message to be removed, got: %v", payload)
+	}
+
+	for i, test := range tests {
+		handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+			DedupeMessage:   true,
+			LogName:         "slogx-test",
+			MessageKey:      test.messageKey,
+			ProjectID:       "slogx-test-project",
+			RecordFormatter: test.formatter,
+		})
+		slog.New(handler).Info("hello", slog.String("user", "jdoe"))
+
+		entries := w.Entries()
+		if len(entries) != 1 {
+			t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+		}
+		payload := decodePayload(t, entries[0])
+		delete(payload, "time")
+		expected := map[string]any{}
+		if err := json.Unmarshal([]byte(test.expected), &expected); err != nil {
+			t.Fatalf("invalid expected payload: %s", err.Error())
+		}
+		if !reflect.DeepEqual(payload, expected) {
+			t.Errorf("test %d: expected payload %v, got %v", i, expected, payload)
+		}
+	}
+}
+
+func TestGoogleCloudLoggingHandlerPayloadParsing(t *testing.T) {
+	payloads := []string{
+		`{}`,
+		` { "a\"b" : [1, {"c": "]}"}] , "n" : -1.5e3 , "t":true, "z": null } `,
+		`{"message":"replaced","unicode":"caf\u00e9 \u2028","nested":{"deep":[[],{}]}}`,
+	}
+	for _, p := range payloads {
+		handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+			LogName:         "slogx-test",
+			ProjectID:       "slogx-test-project",
+			RecordFormatter: &staticFormatter{payload: p},
+		})
+		slog.New(handler).Info("hello \"world\" <&>")
+
+		payload := decodePayload(t, w.Entries()[0])
+		expected := map[string]any{}
+		if err := json.Unmarshal([]byte(p), &expected); err != nil {
+			t.Fatalf("invalid payload %q: %s", p, err.Error())
+		}
+		expected["message"] = "hello \"world\" <&>"
+		if !reflect.DeepEqual(payload, expected) {
+			t.Errorf("expected payload %v, got %v", expected, payload)
+		}
+	}
+}
+
+func TestGoogleCloudLoggingHandlerPayloadBufferReuse(t *testing.T) {
+	var out bytes.Buffer
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		DryRun:       true,
+		DryRunWriter: &out,
+		EnableAsync:  true,
+		LogName:      "slogx-test",
+		ProjectID:    "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	logger := slog.New(handler)
+	for i := 0; i < 200; i++ {
+		logger.Info(fmt.Sprintf("message %d", i), slog.Int("i", i), slog.String("padding", strings.Repeat("x", i)))
+	}
+	if err := handler.Shutdown(false); err != nil {
+		t.Fatalf("failed to shut down handler: %s", err.Error())
+	}
+
+	seen := map[int]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		entry := struct {
+			Payload struct {
+				I       int    `json:"i"`
+				Message string `json:"message"`
+				Padding string `json:"padding"`
+			} `json:"payload"`
+		}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("expected entry to be valid JSON: %s", err.Error())
+		}
+		p := entry.Payload
+		if p.Message != fmt.Sprintf("message %d", p.I) || len(p.Padding) != p.I {
+			t.Errorf("expected payload buffers not to be shared between entries, got: %s", line)
+		}
+		seen[p.I] = true
+	}
+	if len(seen) != 200 {
+		t.Errorf("expected 200 distinct entries, got %d", len(seen))
+	}
+}
+
+func TestGoogleCloudLoggingHandlerOptionsFormatter(t *testing.T) {
+	for _, uses := range []bool{true, false} {
+		f := &optionsFormatter{uses: uses}
+		handler, _ := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+			LogName:         "slogx-options",
+			ProjectID:       "slogx-test-project",
+			RecordFormatter: f,
+		})
+		slog.New(handler).Info("options")
+		if got := f.options.LogName == "slogx-options"; got != uses {
+			t.Errorf("expected options to be in the context: %t, got log name %q", uses, f.options.LogName)
+		}
+	}
+}
+
+func TestGoogleCloudLoggingHandlerOptions(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		Labels:    map[string]string{"service": "checkout"},
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	opts := handler.Options()
+	if opts.Level != slog.LevelInfo || opts.MessageKey != slogxgooglecloudlogging.DefaultMessageKey {
+		t.Errorf("expected the defaults to have been applied, got level %v and message key %q", opts.Level,
+			opts.MessageKey)
+	}
+
+	opts.Labels["service"] = "changed"
+	opts.MessageKey = "changed"
+	slog.New(handler).Info("unchanged")
+	entry := w.Entries()[0]
+	if entry.Labels["service"] != "checkout" || decodePayload(t, entry)["message"] != "unchanged" {
+		t.Errorf("expected changes to the returned options to have no effect on the handler, got: %v", entry)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerSortKeys(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:         "slogx-test",
+		ProjectID:       "slogx-test-project",
+		RecordFormatter: &shuffledFormatter{},
+		SortKeys:        true,
+	})
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "sorted", 0)
+	r.AddAttrs(slog.String("zeta", "z"), slog.Int("alpha", 1), slog.Any("list", []map[string]int{{"b": 2, "a": 1}}),
+		slog.Group("request", slog.String("method", "GET"), slog.Int("status", 200), slog.String("id", "abc"),
+			slog.Group("client", slog.String("ip", "127.0.0.1"), slog.String("agent", "test"))))
+	for i := 0; i < 20; i++ {
+		if err := handler.Handle(context.Background(), r); err != nil {
+			t.Fatalf("failed to handle record: %s", err.Error())
+		}
+	}
+
+	expected := `{"alpha":1,"list":[{"a":1,"b":2}],"message":"sorted","msg":"sorted",` +
+		`"request":{"client":{"agent":"test","ip":"127.0.0.1"},"id":"abc","method":"GET","status":200},"zeta":"z"}`
+	for i, entry := range w.Entries() {
+		if payload := string(entry.Payload.(json.RawMessage)); payload != expected {
+			t.Errorf("expected run %d to produce %s, got %s", i, expected, payload)
+		}
+	}
+}
+
+func TestGoogleCloudLoggingHandlerAfterFormat(t *testing.T) {
+	errRejected := errors.New("rejected")
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		AfterFormat: func(_ context.Context, payload []byte) ([]byte, error) {
+			if bytes.Contains(payload, []byte("reject")) {
+				return nil, errRejected
+			}
+			if bytes.Contains(payload, []byte("corrupt")) {
+				return payload[:len(payload)-1], nil
+			}
+			return bytes.ReplaceAll(payload, []byte("123-45-6789"), []byte("***-**-****")), nil
+		},
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	ctx := context.Background()
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "masked", 0)
+	r.AddAttrs(slog.String("ssn", "123-45-6789"))
+	if err := handler.Handle(ctx, r); err != nil {
+		t.Fatalf("failed to handle record: %s", err.Error())
+	}
+	if err := handler.Handle(ctx, slog.NewRecord(time.Now(), slog.LevelInfo, "reject", 0)); !errors.Is(err, errRejected) {
+		t.Errorf("expected the error returned by AfterFormat, got: %v", err)
+	}
+	if err := handler.Handle(ctx, slog.NewRecord(time.Now(), slog.LevelInfo, "corrupt", 0)); err == nil {
+		t.Errorf("expected an error for an invalid JSON payload returned by AfterFormat")
+	}
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	if ssn := decodePayload(t, entries[0])["ssn"]; ssn != "***-**-****" {
+		t.Errorf("expected payload to be transformed by AfterFormat, got ssn: %v", ssn)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerRedaction(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+		RedactFunc: func(key string, value slog.Value) (slog.Value, bool) {
+			if key == "user.token" {
+				return value, false
+			}
+			if key == "email" {
+				return slog.StringValue("j***@example.com"), true
+			}
+			return value, true
+		},
+		RedactKeys: []string{"password", "user.ssn"},
+	})
+	logger := slog.New(handler).With(slog.String("password", "hunter2"))
+	logger.Info("redacted", slog.String("email", "jdoe@example.com"), slog.Group("user", slog.String("name", "jdoe"),
+		slog.String("ssn", "123-45-6789"), slog.String("token", "abc")), slog.String("ssn", "not nested"))
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	payload := decodePayload(t, entries[0])
+	if payload["password"] != slogxgooglecloudlogging.RedactedValue || payload["ssn"] != "not nested" {
+		t.Errorf("expected top-level keys to be matched by their full path, got: %v", payload)
+	}
+	if payload["email"] != "j***@example.com" {
+		t.Errorf("expected value returned by RedactFunc to be used, got: %v", payload["email"])
+	}
+	expected := map[string]any{"name": "jdoe", "ssn": slogxgooglecloudlogging.RedactedValue}
+	if user, _ := payload["user"].(map[string]any); !maps.Equal(user, expected) {
+		t.Errorf("expected nested values to be redacted and dropped, got: %v", payload["user"])
+	}
+}
+
+func TestGoogleCloudLoggingHandlerLogValuer(t *testing.T) {
+	var extracted slog.Value
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LabelExtractor: func(_ context.Context, _ slog.Record, attrs []slog.Attr) map[string]string {
+			for _, a := range attrs {
+				if a.Key == "user" {
+					extracted = a.Value
+				}
+			}
+			return nil
+		},
+		LogName:    "slogx-test",
+		ProjectID:  "slogx-test-project",
+		RedactKeys: []string{"user.password"},
+	})
+	logger := slog.New(handler).With(slogxgooglecloudlogging.DefaultLabelGroupName, labelsValuer{"region": "us-east1"})
+	logger.Info("resolved", "user", userValuer{name: "jdoe", password: "hunter2"})
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	if extracted.Kind() != slog.KindGroup {
+		t.Errorf("expected the extractor to see the resolved group, got kind %s", extracted.Kind())
+	}
+	expected := map[string]any{"name": "jdoe", "password": slogxgooglecloudlogging.RedactedValue}
+	if user := decodePayload(t, entries[0])["user"]; !reflect.DeepEqual(user, expected) {
+		t.Errorf("expected the resolved group %v to be redacted, got: %v", expected, user)
+	}
+	if entries[0].Labels["region"] != "us-east1" {
+		t.Errorf("expected a label group returned by a LogValuer to be promoted, got: %v", entries[0].Labels)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerContextSeverity(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		Level:       slog.LevelDebug,
+		LogName:     "slogx-test",
+		MinSeverity: logging.Info,
+		ProjectID:   "slogx-test-project",
+	})
+	logger := slog.New(handler)
+	ctx := slogxgooglecloudlogging.WithMinSeverity(context.Background(), logging.Notice)
+	logger.DebugContext(context.Background(), "dropped")
+	logger.DebugContext(ctx, "escalated debug")
+	logger.InfoContext(ctx, "escalated info")
+	logger.ErrorContext(ctx, "unchanged error")
+
+	entries := w.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries to be written, got %d", len(entries))
+	}
+	for i, severity := range []logging.Severity{logging.Notice, logging.Notice, logging.Error} {
+		if entries[i].Severity != severity {
+			t.Errorf("expected entry %d to have severity %s, got %s", i, severity, entries[i].Severity)
+		}
+	}
+
+	// a threshold carried by the context discards entries instead of raising their severity
+	ctx = slogxgooglecloudlogging.WithSeverityThreshold(context.Background(), logging.Warning)
+	logger.InfoContext(ctx, "suppressed info")
+	logger.WarnContext(ctx, "kept warning")
+	logger.InfoContext(slogxgooglecloudlogging.WithMinSeverity(ctx, logging.Warning), "escalated past the threshold")
+	entries = w.Entries()[3:]
+	if len(entries) != 2 {
+		t.Fatalf("expected entries below the context threshold to be discarded, got %d entries", len(entries))
+	}
+	for i, severity := range []logging.Severity{logging.Warning, logging.Warning} {
+		if entries[i].Severity != severity {
+			t.Errorf("expected entry %d to have severity %s, got %s", i, severity, entries[i].Severity)
+		}
+	}
+}
+
+func TestGoogleCloudLoggingHandlerNestedGroupAttrs(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	logger := slog.New(handler.WithGroup("a").WithGroup("b").WithAttrs([]slog.Attr{slog.String("k", "v")}))
+	logger.Info("nested group attributes", slog.String("r", "v"))
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	payload := decodePayload(t, entries[0])
+	a, _ := payload["a"].(map[string]any)
+	b, _ := a["b"].(map[string]any)
+	if b["k"] != "v" {
+		t.Errorf("expected handler attribute to be nested under 'a.b', got: %v", payload)
+	}
+	if b["r"] != "v" {
+		t.Errorf("expected record attribute to be nested under 'a.b', got: %v", payload)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerEmptyGroups(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	logger := slog.New(handler.WithGroup("x").WithAttrs([]slog.Attr{slog.Group("y")}))
+	logger.Info("empty groups", slog.Group("z", slog.Group("zz")))
+	slog.New(handler).Info("non-empty group", slog.Group("z", slog.String("k", "v"), slog.Group("zz")))
+
+	entries := w.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries to be written, got %d", len(entries))
+	}
+	payload := decodePayload(t, entries[0])
+	if _, ok := payload["x"]; ok {
+		t.Errorf("expected empty group 'x' to be omitted, got: %v", payload)
+	}
+	if _, ok := payload["z"]; ok {
+		t.Errorf("expected empty group 'z' to be omitted, got: %v", payload)
+	}
+	payload = decodePayload(t, entries[1])
+	z, _ := payload["z"].(map[string]any)
+	if z["k"] != "v" {
+		t.Errorf("expected non-empty group 'z' to be kept, got: %v", payload)
+	}
+	if _, ok := z["zz"]; ok {
+		t.Errorf("expected empty group 'z.zz' to be omitted, got: %v", payload)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerDisableAttrDeduplication(t *testing.T) {
+	f := &capturingFormatter{}
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(&discardWriter{}, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		DisableAttrDeduplication: true,
+		LogName:                  "slogx-test",
+		ProjectID:                "slogx-test-project",
+		RecordFormatter:          f,
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	logger := slog.New(handler.WithAttrs([]slog.Attr{slog.String("event", "started")}))
+	logger.Info("repeated keys", slog.String("event", "retried"), slog.String("event", "finished"))
+
+	events := []string{}
+	for _, attr := range f.attrs {
+		if attr.Key == "event" {
+			events = append(events, attr.Value.String())
+		}
+	}
+	if !slices.Equal(events, []string{"started", "retried", "finished"}) {
+		t.Errorf("expected all repeated attributes to be passed to the formatter in order, got: %v", events)
+	}
+
+	// fields set by the handler replace every repeated copy of their key in the payload
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		DisableAttrDeduplication: true,
+		IncludeNumericSeverity:   true,
+		LogName:                  "slogx-test",
+		ProjectID:                "slogx-test-project",
+		RecordFormatter: &staticFormatter{
+			payload: `{"message":"first","severity_number":1,"user":"jdoe","message":"second","severity_number":2}`,
+		},
+	})
+	slog.New(handler).Warn("repeated fields")
+	raw, _ := w.Entries()[0].Payload.(json.RawMessage)
+	if expected := `{"message":"repeated fields","severity_number":400,"user":"jdoe"}`; string(raw) != expected {
+		t.Errorf("expected the payload to contain each field set by the handler once, got: %s", raw)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerStaticLabels(t *testing.T) {
+	labels := map[string]string{"service": "checkout", "version": "1.4.2"}
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LabelExtractor: func(_ context.Context, _ slog.Record, _ []slog.Attr) map[string]string {
+			return map[string]string{"version": "2.0.0"}
+		},
+		Labels:    labels,
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	labels["service"] = "changed"
+	slog.New(handler).Info("static labels")
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	if entries[0].Labels["service"] != "checkout" {
+		t.Errorf("expected static label to be unaffected by later changes, got: %v", entries[0].Labels)
+	}
+	if entries[0].Labels["version"] != "2.0.0" {
+		t.Errorf("expected extracted label to override static label, got: %v", entries[0].Labels)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerContextLabels(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LabelExtractor: func(_ context.Context, _ slog.Record, _ []slog.Attr) map[string]string {
+			return map[string]string{"route": "/checkout"}
+		},
+		Labels:    map[string]string{"service": "checkout", "tenant": "none"},
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	ctx := slogxgooglecloudlogging.WithLabels(context.Background(), map[string]string{"tenant": "acme", "route": "/"})
+	ctx = slogxgooglecloudlogging.WithLabels(ctx, map[string]string{"request_id": "abc123"})
+	slog.New(handler).InfoContext(ctx, "context labels")
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	expected := map[string]string{"service": "checkout", "tenant": "acme", "route": "/checkout", "request_id": "abc123"}
+	if !maps.Equal(entries[0].Labels, expected) {
+		t.Errorf("expected labels %v, got: %v", expected, entries[0].Labels)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerDerivedLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions
+		log      func(handler slog.Handler)
+		key      string
+		expected string
+	}{
+		{
+			name: "FunctionNameLabelKey",
+			opts: slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{FunctionNameLabelKey: "function"},
+			log: func(handler slog.Handler) {
+				slog.New(handler).Info("with pc")
+				_ = handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "without pc", 0))
+			},
+			key:      "function",
+			expected: "go.innotegrity.dev/slogx-googlecloudlogging_test.TestGoogleCloudLoggingHandlerDerivedLabels.func1",
+		},
+		{
+			name: "GroupPathLabelKey",
+			opts: slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{GroupPathLabelKey: "log_group"},
+			log: func(handler slog.Handler) {
+				slog.New(handler.WithGroup("a").WithGroup("b").WithGroup("c")).Info("grouped")
+				slog.New(handler).Info("ungrouped")
+			},
+			key:      "log_group",
+			expected: "a.b.c",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.opts.LogName = "slogx-test"
+			test.opts.ProjectID = "slogx-test-project"
+			handler, w := newTestHandler(t, test.opts)
+			test.log(handler)
+
+			entries := w.Entries()
+			if len(entries) != 2 {
+				t.Fatalf("expected 2 entries to be written, got %d", len(entries))
+			}
+			if label := entries[0].Labels[test.key]; label != test.expected {
+				t.Errorf("expected label %s to be %q, got: %v", test.key, test.expected, entries[0].Labels)
+			}
+			if label, ok := entries[1].Labels[test.key]; ok {
+				t.Errorf("expected label %s to be omitted, got: %q", test.key, label)
+			}
+		})
+	}
+}
+
+func TestGoogleCloudLoggingHandlerCorrelationID(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		CorrelationIDExtractor: func(ctx context.Context) string {
+			id, _ := ctx.Value(correlationIDKey{}).(string)
+			return id
+		},
+		CorrelationIDLabelKey: "correlation",
+		LogName:               "slogx-test",
+		ProjectID:             "slogx-test-project",
+	})
+	logger := slog.New(handler)
+	logger.InfoContext(context.WithValue(context.Background(), correlationIDKey{}, "job-42"), "with correlation ID")
+	logger.Info("without correlation ID")
+
+	entries := w.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries to be written, got %d", len(entries))
+	}
+	if id := decodePayload(t, entries[0])[slogxgooglecloudlogging.DefaultCorrelationIDKey]; id != "job-42" {
+		t.Errorf("expected correlation ID 'job-42' in the payload, got: %v", id)
+	}
+	if entries[0].Labels["correlation"] != "job-42" {
+		t.Errorf("expected correlation ID label 'job-42', got: %v", entries[0].Labels)
+	}
+	if _, ok := decodePayload(t, entries[1])[slogxgooglecloudlogging.DefaultCorrelationIDKey]; ok {
+		t.Errorf("expected correlation ID to be omitted from the payload when empty")
+	}
+	if _, ok := entries[1].Labels["correlation"]; ok {
+		t.Errorf("expected correlation ID label to be omitted when empty, got: %v", entries[1].Labels)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerNumericSeverity(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		IncludeNumericSeverity: true,
+		LogName:                "slogx-test",
+		ProjectID:              "slogx-test-project",
+	})
+	logger := slog.New(handler)
+	logger.Error("numeric severity")
+	logger.InfoContext(slogxgooglecloudlogging.WithMinSeverity(context.Background(), logging.Warning), "escalated")
+	custom, err := handler.Clone(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:            "slogx-test",
+		NumericSeverityKey: "severity_code",
+	})
+	if err != nil {
+		t.Fatalf("failed to clone Google Cloud Logging Handler: %s", err.Error())
+	}
+	slog.New(custom).Info("without numeric severity")
+
+	entries := w.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries to be written, got %d", len(entries))
+	}
+	if severity := decodePayload(t, entries[0])[slogxgooglecloudlogging.DefaultNumericSeverityKey]; severity != float64(logging.Error) {
+		t.Errorf("expected numeric severity %d in the payload, got: %v", logging.Error, severity)
+	}
+	if severity := decodePayload(t, entries[1])[slogxgooglecloudlogging.DefaultNumericSeverityKey]; severity != float64(logging.Warning) {
+		t.Errorf("expected escalated numeric severity %d in the payload, got: %v", logging.Warning, severity)
+	}
+	if _, ok := decodePayload(t, entries[2])["severity_code"]; ok {
+		t.Errorf("expected numeric severity to be omitted unless IncludeNumericSeverity is enabled")
+	}
+}
+
+func TestGoogleCloudLoggingHandlerResourceExtractor(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+		ResourceExtractor: func(_ context.Context, r slog.Record) *monitoredres.MonitoredResource {
+			var pod string
+			r.Attrs(func(a slog.Attr) bool {
+				if a.Key == "pod" {
+					pod = a.Value.String()
+				}
+				return true
+			})
+			if pod == "" {
+				return nil
+			}
+			return &monitoredres.MonitoredResource{Type: "k8s_pod", Labels: map[string]string{"pod_name": pod}}
+		},
+	})
+	logger := slog.New(handler)
+	logger.Info("from pod", slog.String("pod", "web-1"))
+	logger.Info("from the process")
+
+	entries := w.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries to be written, got %d", len(entries))
+	}
+	if entries[0].Resource == nil || entries[0].Resource.Type != "k8s_pod" || entries[0].Resource.Labels["pod_name"] != "web-1" {
+		t.Errorf("expected entry to be associated with the extracted resource, got: %v", entries[0].Resource)
+	}
+	if entries[1].Resource != nil {
+		t.Errorf("expected entry to fall back to the common resource, got: %v", entries[1].Resource)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerHTTPRequestExtractor(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		HTTPRequestExtractor: func(_ context.Context, attrs []slog.Attr) *logging.HTTPRequest {
+			for _, a := range attrs {
+				if a.Key == "path" {
+					return &logging.HTTPRequest{
+						Request: httptest.NewRequest(http.MethodGet, a.Value.String(), nil),
+						Status:  http.StatusOK,
+					}
+				}
+			}
+			return nil
+		},
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	logger := slog.New(handler)
+	logger.With(slog.String("path", "/checkout")).Info("handled request")
+	logger.Info("outside of a request")
+
+	entries := w.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries to be written, got %d", len(entries))
+	}
+	req := entries[0].HTTPRequest
+	if req == nil || req.Request.Method != http.MethodGet || req.Request.URL.Path != "/checkout" || req.Status != http.StatusOK {
+		t.Errorf("expected entry to contain the extracted HTTP request, got: %+v", req)
+	}
+	if entries[1].HTTPRequest != nil {
+		t.Errorf("expected no HTTP request when the extractor returns nil, got: %+v", entries[1].HTTPRequest)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerInsertIDFunc(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		InsertIDFunc: func(r slog.Record, attrs []slog.Attr) string {
+			for _, a := range attrs {
+				if a.Key == "event" {
+					return r.Message + "-" + a.Value.String()
+				}
+			}
+			return ""
+		},
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	logger := slog.New(handler)
+	logger.Info("order", slog.String("event", "1234"))
+	logger.Info("without an event")
+
+	entries := w.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries to be written, got %d", len(entries))
+	}
+	if entries[0].InsertID != "order-1234" {
+		t.Errorf("expected entry to use the generated insert ID, got: %q", entries[0].InsertID)
+	}
+	if entries[1].InsertID != "" {
+		t.Errorf("expected the insert ID to be left unset when the function returns an empty string, got: %q",
+			entries[1].InsertID)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerIncludeSourceLocation(t *testing.T) {
+	newLogger := func(include bool) (*slog.Logger, *memoryWriter) {
+		handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+			IncludeSourceLocation: include,
+			LogName:               "slogx-test",
+			ProjectID:             "slogx-test-project",
+		})
+		return slog.New(handler), w
+	}
+
+	logger, w := newLogger(true)
+	_, file, line, _ := runtime.Caller(0)
+	logger.Info("with source location")
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	location := entries[0].SourceLocation
+	if location == nil {
+		t.Fatalf("expected entry to contain the source location")
+	}
+	if location.File != file || location.Line != int64(line+1) ||
+		!strings.HasSuffix(location.Function, ".TestGoogleCloudLoggingHandlerIncludeSourceLocation") {
+		t.Errorf("expected the source location %s:%d in the test function, got: %s:%d in %s", file, line+1,
+			location.File, location.Line, location.Function)
+	}
+
+	logger, w = newLogger(false)
+	logger.Info("without source location")
+	if entries := w.Entries(); len(entries) != 1 || entries[0].SourceLocation != nil {
+		t.Errorf("expected no source location when the option is disabled, got: %v", entries)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerOperationExtractor(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName: "slogx-test",
+		OperationExtractor: func(ctx context.Context) *loggingpb.LogEntryOperation {
+			id, _ := ctx.Value(operationIDKey{}).(string)
+			if id == "" {
+				return nil
+			}
+			return &loggingpb.LogEntryOperation{Id: id, Producer: "slogx-test", First: true}
+		},
+		ProjectID: "slogx-test-project",
+	})
+	logger := slog.New(handler)
+	logger.InfoContext(context.WithValue(context.Background(), operationIDKey{}, "op-1"), "operation started")
+	logger.Info("outside of an operation")
+
+	entries := w.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries to be written, got %d", len(entries))
+	}
+	op := entries[0].Operation
+	if op == nil || op.Id != "op-1" || op.Producer != "slogx-test" || !op.First {
+		t.Errorf("expected entry to contain the extracted operation, got: %v", op)
+	}
+	if entries[1].Operation != nil {
+		t.Errorf("expected no operation when the extractor returns nil, got: %v", entries[1].Operation)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerAddCallerField(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		AddCallerField: true,
+		LogName:        "slogx-test",
+		ProjectID:      "slogx-test-project",
+	})
+	_, file, line, _ := runtime.Caller(0)
+	slog.New(handler).Info("with caller")
+	if err := handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "without a caller", 0)); err != nil {
+		t.Fatalf("failed to handle record: %s", err.Error())
+	}
+
+	entries := w.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries to be written, got %d", len(entries))
+	}
+	if caller := decodePayload(t, entries[0])["caller"]; caller != fmt.Sprintf("%s:%d", file, line+1) {
+		t.Errorf("expected the caller field to contain %s:%d, got: %v", file, line+1, caller)
+	}
+	if caller, ok := decodePayload(t, entries[1])["caller"]; ok {
+		t.Errorf("expected no caller field for a record without a program counter, got: %v", caller)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerSpecialPayloadKeys(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	slog.New(handler).Info("special keys",
+		slog.Group("logging.googleapis.com/labels", slog.String("region", "us-east1")),
+		slog.String("logging.googleapis.com/trace", "4bf92f3577b34da6a3ce929d0e0e4736"),
+		slog.String("logging.googleapis.com/spanId", "00f067aa0ba902b7"),
+		slog.Bool("logging.googleapis.com/trace_sampled", true),
+		slog.String("user", "jdoe"))
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.Labels["region"] != "us-east1" {
+		t.Errorf("expected labels to be taken from the special labels key, got: %v", entry.Labels)
+	}
+	if entry.Trace != "projects/slogx-test-project/traces/4bf92f3577b34da6a3ce929d0e0e4736" || !entry.TraceSampled {
+		t.Errorf("expected trace to be taken from the special trace keys, got: %s (sampled: %t)", entry.Trace,
+			entry.TraceSampled)
+	}
+	if entry.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("expected span ID to be taken from the special span ID key, got: %s", entry.SpanID)
+	}
+	payload := decodePayload(t, entry)
+	for key := range payload {
+		if strings.HasPrefix(key, "logging.googleapis.com/") {
+			t.Errorf("expected special key %q to be removed from the payload, got: %v", key, payload)
+		}
+	}
+	if payload["user"] != "jdoe" {
+		t.Errorf("expected other attributes to remain in the payload, got: %v", payload)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerLabelAttrPrefix(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LabelAttrPrefix: "label.",
+		Labels:          map[string]string{"region": "us-central1"},
+		LogName:         "slogx-test",
+		ProjectID:       "slogx-test-project",
+	})
+	slog.New(handler).Info("promoted labels", slog.String("label.region", "us-east1"), slog.Int("label.shard", 3),
+		slog.String("user", "jdoe"))
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	if entries[0].Labels["region"] != "us-east1" || entries[0].Labels["shard"] != "3" {
+		t.Errorf("expected prefixed attributes to be promoted to labels, got: %v", entries[0].Labels)
+	}
+	payload := decodePayload(t, entries[0])
+	if _, ok := payload["label.region"]; ok {
+		t.Errorf("expected promoted attribute to be removed from the payload, got: %v", payload)
+	}
+	if payload["user"] != "jdoe" {
+		t.Errorf("expected other attributes to remain in the payload, got: %v", payload)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerLabelGroup(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		Labels:    map[string]string{"region": "us-central1"},
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	logger := slog.New(handler)
+	logger.WithGroup(slogxgooglecloudlogging.DefaultLabelGroupName).Info("grouped labels", "region", "us-east1",
+		slog.Int("shard", 3), slog.Group("build", slog.String("version", "1.2.3")))
+	logger.Info("inline labels", slog.Group(slogxgooglecloudlogging.DefaultLabelGroupName, slog.String("shard", "4")),
+		slog.String("user", "jdoe"))
+
+	entries := w.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries to be written, got %d", len(entries))
+	}
+	expected := map[string]string{"build.version": "1.2.3", "region": "us-east1", "shard": "3"}
+	if !maps.Equal(entries[0].Labels, expected) {
+		t.Errorf("expected labels %v, got: %v", expected, entries[0].Labels)
+	}
+	expected = map[string]string{"region": "us-central1", "shard": "4"}
+	if !maps.Equal(entries[1].Labels, expected) {
+		t.Errorf("expected labels %v, got: %v", expected, entries[1].Labels)
+	}
+	payload := decodePayload(t, entries[1])
+	if _, ok := payload[slogxgooglecloudlogging.DefaultLabelGroupName]; ok {
+		t.Errorf("expected label group to be removed from the payload, got: %v", payload)
+	}
+	if payload["user"] != "jdoe" {
+		t.Errorf("expected other attributes to remain in the payload, got: %v", payload)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerNestedGroups(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	slog.New(handler).WithGroup("request").Info("nested groups", slog.String("method", "GET"),
+		slog.Group("user", slog.String("id", "42"), slog.Group("org", slog.String("name", "acme"))))
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	payload := decodePayload(t, entries[0])
+	expected := map[string]any{
+		"method": "GET",
+		"user": map[string]any{
+			"id":  "42",
+			"org": map[string]any{"name": "acme"},
+		},
+	}
+	if !reflect.DeepEqual(payload["request"], expected) {
+		t.Errorf("expected groups to be encoded as nested objects %v, got: %v", expected, payload)
+	}
+	for key := range payload {
+		if strings.Contains(key, ".") {
+			t.Errorf("expected no flattened keys in the payload, got: %s", key)
+		}
+	}
+}
+
+func TestGoogleCloudLoggingHandlerErrorReporting(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		EnableErrorReporting: true,
+		LogName:              "slogx-test",
+		ProjectID:            "slogx-test-project",
+		ServiceContext:       slogxgooglecloudlogging.ServiceContext{Service: "checkout", Version: "1.4.2"},
+	})
+	logger := slog.New(handler)
+	logger.Error("payment failed", slog.String("stack", "goroutine 1 [running]:\nmain.main()"))
+	logger.Error("payment failed without a stack")
+	logger.Info("payment succeeded")
+
+	entries := w.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries to be written, got %d", len(entries))
+	}
+	payload := decodePayload(t, entries[0])
+	if payload["@type"] != "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent" {
+		t.Errorf("expected error entry to have the ReportedErrorEvent type, got: %v", payload)
+	}
+	if payload["message"] != "payment failed\ngoroutine 1 [running]:\nmain.main()" {
+		t.Errorf("expected stack trace to be appended to the message, got: %v", payload["message"])
+	}
+	if stack, ok := payload["stack"]; ok {
+		t.Errorf("expected stack trace to be moved into the message, got: %v", stack)
+	}
+	serviceContext, _ := payload["serviceContext"].(map[string]any)
+	if serviceContext["service"] != "checkout" || serviceContext["version"] != "1.4.2" {
+		t.Errorf("expected service context to be set, got: %v", payload)
+	}
+	payload = decodePayload(t, entries[1])
+	errorContext, _ := payload["context"].(map[string]any)
+	if _, ok := errorContext["reportLocation"]; !ok {
+		t.Errorf("expected report location to be set when there is no stack trace, got: %v", payload)
+	}
+	payload = decodePayload(t, entries[2])
+	if _, ok := payload["@type"]; ok {
+		t.Errorf("expected info entry not to be formatted for Error Reporting, got: %v", payload)
+	}
+
+	// the stack trace is taken from the configured key and appended to the message under the configured key
+	handler, w = newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		EnableErrorReporting: true,
+		LogName:              "slogx-test",
+		MessageKey:           "msg",
+		ProjectID:            "slogx-test-project",
+		ServiceContext:       slogxgooglecloudlogging.ServiceContext{Service: "checkout", Version: "1.4.2"},
+		StackTraceKey:        "stacktrace",
+	})
+	slog.New(handler).Error("payment failed", slog.String("stacktrace", "main.main()"), slog.String("stack", "kept"))
+	payload = decodePayload(t, w.Entries()[0])
+	if payload["@type"] != "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent" {
+		t.Errorf("expected error entry to have the ReportedErrorEvent type, got: %v", payload)
+	}
+	if payload["msg"] != "payment failed\nmain.main()" {
+		t.Errorf("expected stack trace to be appended to the message under the configured key, got: %v", payload)
+	}
+	if _, ok := payload["message"]; ok {
+		t.Errorf("expected no message under the default key, got: %v", payload)
+	}
+	if stack, ok := payload["stacktrace"]; ok {
+		t.Errorf("expected stack trace to be moved out of the configured key, got: %v", stack)
+	}
+	if payload["stack"] != "kept" {
+		t.Errorf("expected the attribute under the default stack trace key to be kept, got: %v", payload)
+	}
+	serviceContext, _ = payload["serviceContext"].(map[string]any)
+	if serviceContext["service"] != "checkout" || serviceContext["version"] != "1.4.2" {
+		t.Errorf("expected service context to be set, got: %v", payload)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerServiceContext(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:        "slogx-test",
+		ProjectID:      "slogx-test-project",
+		ServiceContext: slogxgooglecloudlogging.ServiceContext{Service: "checkout", Version: "1.4.2"},
+	})
+	slog.New(handler).Info("service context")
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	payload := decodePayload(t, entries[0])
+	serviceContext, _ := payload["serviceContext"].(map[string]any)
+	if serviceContext["service"] != "checkout" || serviceContext["version"] != "1.4.2" {
+		t.Errorf("expected service context to be added to the payload, got: %v", payload)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerSampler(t *testing.T) {
+	count := 0
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+		Sampler: func(r slog.Record) bool {
+			if r.Level >= slog.LevelWarn {
+				return true
+			}
+			count++
+			return count%5 == 1
+		},
+	})
+	logger := slog.New(handler)
+	for i := 0; i < 10; i++ {
+		logger.Info("sampled message")
+	}
+	logger.Warn("kept message")
+
+	entries := w.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries to be written, got %d", len(entries))
+	}
+	if entries[2].Severity != logging.Warning {
+		t.Errorf("expected warning to always be written, got severity %s", entries[2].Severity)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerSeverityOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions
+		log      func(logger *slog.Logger)
+		expected []logging.Severity
+	}{
+		{
+			name: "LevelSeverityOverrides",
+			opts: slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+				LevelSeverityOverrides: map[slogx.Level]logging.Severity{slogx.LevelNotice: logging.Info},
+			},
+			log: func(logger *slog.Logger) {
+				logger.Log(context.Background(), slog.Level(slogx.LevelNotice), "overridden")
+				logger.Warn("not overridden")
+			},
+			expected: []logging.Severity{logging.Info, logging.Warning},
+		},
+		{
+			name: "MinSeverity",
+			opts: slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+				Level:       slogx.LevelTrace,
+				MinSeverity: logging.Warning,
+			},
+			log: func(logger *slog.Logger) {
+				logger.Debug("debug message")
+				logger.Info("info message")
+				logger.Warn("warning message")
+				logger.Error("error message")
+			},
+			expected: []logging.Severity{logging.Warning, logging.Error},
+		},
+		{
+			name: "SeverityFromPayloadKey",
+			opts: slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+				MinSeverity:            logging.Notice,
+				SeverityFromPayloadKey: "severity",
+			},
+			log: func(logger *slog.Logger) {
+				logger.Info("promoted", slog.String("severity", "ALERT"))
+				logger.Warn("demoted", slog.String("severity", "debug"))
+				logger.Warn("unknown severity", slog.String("severity", "loud"))
+			},
+			expected: []logging.Severity{logging.Alert, logging.Warning},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.opts.LogName = "slogx-test"
+			test.opts.ProjectID = "slogx-test-project"
+			handler, w := newTestHandler(t, test.opts)
+			test.log(slog.New(handler))
+
+			severities := []logging.Severity{}
+			for _, entry := range w.Entries() {
+				severities = append(severities, entry.Severity)
+			}
+			if !slices.Equal(severities, test.expected) {
+				t.Errorf("expected entries with severities %v, got %v", test.expected, severities)
+			}
+		})
+	}
+}
+
+func TestGoogleCloudLoggingHandlerPanicRecovery(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		var lock sync.Mutex
+		reported := []error{}
+		handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(&discardWriter{}, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+			EnableAsync: async,
+			LogName:     "slogx-test",
+			OnError: func(err error, _ slog.Record) {
+				lock.Lock()
+				defer lock.Unlock()
+				reported = append(reported, err)
+			},
+			ProjectID:       "slogx-test-project",
+			RecordFormatter: &panicFormatter{},
+		})
+		if err != nil {
+			t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+		}
+		err = handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "panic", 0))
+		if !async && (err == nil || !strings.Contains(err.Error(), "formatter exploded")) {
+			t.Errorf("expected Handle to return the recovered panic, got: %v", err)
+		}
+		if err := handler.Shutdown(true); async && err == nil {
+			t.Errorf("expected Shutdown to return the recovered panic")
+		}
+
+		lock.Lock()
+		if len(reported) != 1 || !strings.Contains(reported[0].Error(), "formatter exploded") {
+			t.Errorf("expected OnError to receive the recovered panic (async: %t), got: %v", async, reported)
+		}
+		lock.Unlock()
+	}
+}
+
+func TestGoogleCloudLoggingHandlerMaxPayloadBytes(t *testing.T) {
+	var reported error
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:         "slogx-test",
+		MaxPayloadBytes: 1024,
+		OnError: func(err error, _ slog.Record) {
+			reported = err
+		},
+		ProjectID: "slogx-test-project",
+	})
+	slog.New(handler).Info("large request", slog.String("body", strings.Repeat("é", 2048)), slog.String("user", "jdoe"))
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	raw, _ := entries[0].Payload.(json.RawMessage)
+	if len(raw) > 1024 {
+		t.Errorf("expected payload to be truncated to 1024 bytes, got %d bytes", len(raw))
+	}
+	payload := decodePayload(t, entries[0])
+	if payload["truncated"] != true {
+		t.Errorf("expected payload to be marked as truncated, got: %v", payload)
+	}
+	if body, _ := payload["body"].(string); !strings.HasSuffix(body, "...(truncated)") {
+		t.Errorf("expected largest attribute to be truncated, got: %v", payload["body"])
+	}
+	if payload["user"] != "jdoe" || payload["message"] != "large request" {
+		t.Errorf("expected other fields to be left unchanged, got: %v", payload)
+	}
+	if reported == nil {
+		t.Errorf("expected truncation to be reported to OnError")
+	}
+
+	// strings within groups are shortened before the rest of the payload is discarded
+	slog.New(handler).Info("large group", slog.Group("request", slog.String("body", strings.Repeat("a", 2048)),
+		slog.String("method", "POST")), slog.String("user", "jdoe"))
+	entries = w.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries to be written, got %d", len(entries))
+	}
+	raw, _ = entries[1].Payload.(json.RawMessage)
+	if len(raw) > 1024 {
+		t.Errorf("expected payload to be truncated to 1024 bytes, got %d bytes", len(raw))
+	}
+	payload = decodePayload(t, entries[1])
+	request, _ := payload["request"].(map[string]any)
+	if body, _ := request["body"].(string); !strings.HasSuffix(body, "...(truncated)") || request["method"] != "POST" {
+		t.Errorf("expected the largest attribute within the group to be truncated, got: %v", payload["request"])
+	}
+	if payload["user"] != "jdoe" || payload["message"] != "large group" || payload["truncated"] != true {
+		t.Errorf("expected other fields to be left unchanged, got: %v", payload)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerInvalidPayload(t *testing.T) {
+	opts := slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:         "slogx-test",
+		ProjectID:       "slogx-test-project",
+		RecordFormatter: &garbageFormatter{},
+	}
+	handler, w := newTestHandler(t, opts)
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "garbage payload", 0)
+	if err := handler.Handle(context.Background(), r); err == nil {
+		t.Errorf("expected an error for an invalid JSON payload")
+	}
+	if len(w.Entries()) != 0 {
+		t.Errorf("expected no entries to be written for an invalid JSON payload")
+	}
+
+	opts.FallbackToTextPayload = true
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(w, opts)
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	if err := handler.Handle(context.Background(), r); err != nil {
+		t.Fatalf("expected fallback to a text payload, got: %s", err.Error())
+	}
+	entries := w.Entries()
+	if len(entries) != 1 || entries[0].Payload != "garbage payload" {
+		t.Errorf("expected a text payload containing the message, got: %v", entries)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerDisableHTMLEscape(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		DisableHTMLEscape: true,
+		LogName:           "slogx-test",
+		ProjectID:         "slogx-test-project",
+	})
+	slog.New(handler).Info("html characters", slog.String("url", "https://example.com/?a=1&b=<2>"),
+		slog.String("literal", `\u003c`))
+
+	entries := w.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry to be written, got %d", len(entries))
+	}
+	raw, _ := entries[0].Payload.(json.RawMessage)
+	if !strings.Contains(string(raw), `"https://example.com/?a=1&b=<2>"`) {
+		t.Errorf("expected HTML characters to be left unescaped, got: %s", raw)
+	}
+	if payload := decodePayload(t, entries[0]); payload["literal"] != `\u003c` {
+		t.Errorf("expected literal escape sequence to be preserved, got: %v", payload["literal"])
+	}
+}
+
+func TestGoogleCloudLoggingHandlerTextPayload(t *testing.T) {
+	handler, w := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:         "slogx-test",
+		PayloadType:     slogxgooglecloudlogging.PayloadTypeText,
+		ProjectID:       "slogx-test-project",
+		RecordFormatter: &garbageFormatter{},
+	})
+	slog.New(handler).Info("text payload")
+
+	entries := w.Entries()
+	if len(entries) != 1 || entries[0].Payload != "{not json" {
+		t.Errorf("expected a text payload containing the formatted record, got: %v", entries)
+	}
+}
+
+func TestDefaultGoogleCloudLoggingHandlerLevelMapper(t *testing.T) {
+	tests := []struct {
+		level    slog.Level
+		severity logging.Severity
+	}{
+		{level: slog.Level(slogx.LevelTrace - 4), severity: logging.Debug},
+		{level: slog.Level(slogx.LevelTrace), severity: logging.Debug},
+		{level: slog.Level(slogx.LevelDebug + 1), severity: logging.Debug},
+		{level: slog.Level(slogx.LevelInfo), severity: logging.Info},
+		{level: slog.Level(slogx.LevelInfo + 1), severity: logging.Info},
+		{level: slog.Level(slogx.LevelNotice), severity: logging.Notice},
+		{level: slog.Level(slogx.LevelWarn - 1), severity: logging.Notice},
+		{level: slog.Level(slogx.LevelWarn + 2), severity: logging.Warning},
+		{level: slog.Level(slogx.LevelError + 1), severity: logging.Error},
+		{level: slog.Level(slogx.LevelFatal - 1), severity: logging.Error},
+		{level: slog.Level(slogx.LevelFatal), severity: logging.Critical},
+		{level: slog.Level(slogx.LevelFatal + 3), severity: logging.Critical},
+		{level: slog.Level(slogx.LevelPanic), severity: logging.Emergency},
+		{level: slog.Level(slogx.LevelPanic + 10), severity: logging.Emergency},
+	}
+	for _, test := range tests {
+		if severity := slogxgooglecloudlogging.DefaultGoogleCloudLoggingHandlerLevelMapper(test.level); severity != test.severity {
+			t.Errorf("expected level %d to map to severity %s, got %s", test.level, test.severity, severity)
+		}
+	}
+}
+
+func TestStrictLevelMapper(t *testing.T) {
+	var unmapped []slog.Level
+	mapper := slogxgooglecloudlogging.StrictLevelMapper(func(level slog.Leveler) {
+		unmapped = append(unmapped, level.Level())
+	})
+	tests := []struct {
+		level    slog.Level
+		severity logging.Severity
+	}{
+		{level: slog.Level(slogx.LevelTrace), severity: logging.Debug},
+		{level: slog.Level(slogx.LevelDebug), severity: logging.Debug},
+		{level: slog.Level(slogx.LevelInfo), severity: logging.Info},
+		{level: slog.Level(slogx.LevelNotice), severity: logging.Notice},
+		{level: slog.Level(slogx.LevelWarn), severity: logging.Warning},
+		{level: slog.Level(slogx.LevelError), severity: logging.Error},
+		{level: slog.Level(slogx.LevelFatal), severity: logging.Critical},
+		{level: slog.Level(slogx.LevelPanic), severity: logging.Emergency},
+		{level: slog.Level(slogx.LevelTrace - 4), severity: logging.Default},
+		{level: slog.Level(slogx.LevelError + 2), severity: logging.Default},
+	}
+	for _, test := range tests {
+		if severity := mapper(test.level); severity != test.severity {
+			t.Errorf("expected level %d to map to severity %s, got %s", test.level, test.severity, severity)
+		}
+	}
+	if expected := []slog.Level{slog.Level(slogx.LevelTrace - 4), slog.Level(slogx.LevelError + 2)}; !slices.Equal(unmapped, expected) {
+		t.Errorf("expected unmapped levels %v to be reported, got %v", expected, unmapped)
+	}
+	if severity := slogxgooglecloudlogging.StrictLevelMapper(nil)(slog.Level(slogx.LevelError + 2)); severity != logging.Default {
+		t.Errorf("expected unmapped level to map to severity %s, got %s", logging.Default, severity)
+	}
+}
+
+func TestGoogleCloudLoggingHandlerSeverityFor(t *testing.T) {
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(&discardWriter{}, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LevelMapper: func(level slog.Leveler) logging.Severity {
+			if level.Level() >= slog.LevelError {
+				return logging.Alert
+			}
+			return logging.Info
+		},
+		LevelSeverityOverrides: map[slogx.Level]logging.Severity{slogx.LevelNotice: logging.Notice},
+		LogName:                "slogx-test",
+		ProjectID:              "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	tests := []struct {
+		level    slog.Level
+		severity logging.Severity
+	}{
+		{level: slog.LevelDebug, severity: logging.Info},
+		{level: slog.Level(slogx.LevelNotice), severity: logging.Notice},
+		{level: slog.LevelError, severity: logging.Alert},
+	}
+	for _, test := range tests {
+		if severity := handler.SeverityFor(test.level); severity != test.severity {
+			t.Errorf("expected level %d to map to severity %s, got %s", test.level, test.severity, severity)
+		}
+	}
+
+	handler, err = slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(&discardWriter{}, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	if severity := handler.SeverityFor(slog.LevelWarn); severity != logging.Warning {
+		t.Errorf("expected default mapper to be used, got %s", severity)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := slogxgooglecloudlogging.RetryPolicy{
+		InitialBackoff: time.Millisecond,
+		MaxAttempts:    5,
+		MaxBackoff:     5 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	// transient errors should be retried until the write succeeds
+	attempts := 0
+	err := policy.Retry(context.Background(), func(context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return status.Error(codes.Unavailable, "service unavailable")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("expected flaky write to eventually succeed, got: %s", err.Error())
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+
+	// transient errors should not be retried beyond the attempt budget
+	attempts = 0
+	err = policy.Retry(context.Background(), func(context.Context) error {
+		attempts++
+		return status.Error(codes.ResourceExhausted, "quota exceeded")
+	})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted error, got: %v", err)
+	}
+	if attempts != policy.MaxAttempts {
+		t.Errorf("expected %d attempts, got %d", policy.MaxAttempts, attempts)
+	}
+
+	// non-retryable errors should fail fast
+	attempts = 0
+	err = policy.Retry(context.Background(), func(context.Context) error {
+		attempts++
+		return status.Error(codes.PermissionDenied, "permission denied")
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied error, got: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", attempts)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err      error
+		sentinel error
+	}{
+		{err: errors.New("google: could not find default credentials. See https://cloud.google.com/docs/authentication/external/set-up-adc for more information"), sentinel: slogxgooglecloudlogging.ErrMissingCredentials},
+		{err: status.Error(codes.Unauthenticated, "invalid token"), sentinel: slogxgooglecloudlogging.ErrInvalidCredentials},
+		{err: status.Error(codes.PermissionDenied, "permission denied"), sentinel: slogxgooglecloudlogging.ErrPermissionDenied},
+		{err: status.Error(codes.NotFound, "project not found"), sentinel: slogxgooglecloudlogging.ErrProjectNotFound},
+	}
+	for _, test := range tests {
+		err := slogxgooglecloudlogging.ClassifyError(test.err)
+		if !errors.Is(err, test.sentinel) || !errors.Is(err, test.err) {
+			t.Errorf("expected %q to wrap both %q and the original error, got: %v", test.err, test.sentinel, err)
+		}
+		if status.Code(err) != status.Code(test.err) {
+			t.Errorf("expected the gRPC status code of %q to be preserved, got %s", test.err, status.Code(err))
+		}
+	}
+
+	if err := slogxgooglecloudlogging.ClassifyError(status.Error(codes.Unavailable, "unavailable")); status.Code(err) != codes.Unavailable ||
+		err.Error() != "rpc error: code = Unavailable desc = unavailable" {
+		t.Errorf("expected unrecognized errors to be returned as-is, got: %v", err)
+	}
+	if err := slogxgooglecloudlogging.ClassifyError(nil); err != nil {
+		t.Errorf("expected nil, got: %v", err)
+	}
+}
+
+func TestTraceFromHeader(t *testing.T) {
+	const traceID = "105445aa7843bc8bf206b12000100000"
+	tests := []struct {
+		header  string
+		trace   string
+		spanID  string
+		sampled bool
+	}{
+		{header: traceID + "/1;o=1", trace: "projects/p/traces/" + traceID, spanID: "0000000000000001", sampled: true},
+		{header: traceID + "/18446744073709551615;o=0", trace: "projects/p/traces/" + traceID, spanID: "ffffffffffffffff"},
+		{header: traceID + "/1", trace: "projects/p/traces/" + traceID, spanID: "0000000000000001"},
+		{header: traceID + ";o=1", trace: "projects/p/traces/" + traceID, sampled: true},
+		{header: traceID + "/not-a-span;o=1", trace: "projects/p/traces/" + traceID, sampled: true},
+		{header: strings.ToUpper(traceID), trace: "projects/p/traces/" + traceID},
+		{header: ""},
+		{header: "/1;o=1"},
+		{header: "not-a-trace/1;o=1"},
+		{header: "00000000000000000000000000000000/1;o=1"},
+	}
+	for _, test := range tests {
+		trace, spanID, sampled := slogxgooglecloudlogging.TraceFromHeader(test.header, "p")
+		if trace != test.trace || spanID != test.spanID || sampled != test.sampled {
+			t.Errorf("expected header %q to parse as (%q, %q, %t), got (%q, %q, %t)", test.header, test.trace,
+				test.spanID, test.sampled, trace, spanID, sampled)
+		}
+	}
+	if trace, _, _ := slogxgooglecloudlogging.TraceFromHeader(traceID, ""); trace != traceID {
+		t.Errorf("expected bare trace ID without a project ID, got %q", trace)
+	}
+}
+
+func TestTraceFromTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		traceparent string
+		trace       string
+		spanID      string
+		sampled     bool
+	}{
+		{traceparent: "00-" + traceID + "-" + spanID + "-01", trace: "projects/p/traces/" + traceID, spanID: spanID, sampled: true},
+		{traceparent: "00-" + traceID + "-" + spanID + "-00", trace: "projects/p/traces/" + traceID, spanID: spanID},
+		{traceparent: "00-" + traceID + "-" + spanID + "-03", trace: "projects/p/traces/" + traceID, spanID: spanID, sampled: true},
+		{traceparent: "01-" + traceID + "-" + spanID + "-01-extra", trace: "projects/p/traces/" + traceID, spanID: spanID, sampled: true},
+		{traceparent: "00-" + traceID + "-" + spanID + "-01-extra"},
+		{traceparent: "ff-" + traceID + "-" + spanID + "-01"},
+		{traceparent: "0-" + traceID + "-" + spanID + "-01"},
+		{traceparent: "00-00000000000000000000000000000000-" + spanID + "-01"},
+		{traceparent: "00-" + traceID + "-0000000000000000-01"},
+		{traceparent: "00-" + traceID + "-" + spanID + "-1"},
+		{traceparent: "00-" + traceID + "-" + spanID + "-zz"},
+		{traceparent: "00-" + traceID + "-" + spanID},
+		{traceparent: ""},
+	}
+	for _, test := range tests {
+		trace, spanID, sampled := slogxgooglecloudlogging.TraceFromTraceparent(test.traceparent, "p")
+		if trace != test.trace || spanID != test.spanID || sampled != test.sampled {
+			t.Errorf("expected traceparent %q to parse as (%q, %q, %t), got (%q, %q, %t)", test.traceparent,
+				test.trace, test.spanID, test.sampled, trace, spanID, sampled)
+		}
+	}
+}
+
+func BenchmarkGoogleCloudLoggingHandlerEnabledLevel(b *testing.B) {
+	benchmarkGoogleCloudLoggingHandlerLevel(b, slog.LevelInfo)
+}
+
+func BenchmarkGoogleCloudLoggingHandlerDisabledLevel(b *testing.B) {
+	benchmarkGoogleCloudLoggingHandlerLevel(b, slog.LevelError)
+}
+
+func BenchmarkGoogleCloudLoggingHandlerWithAttrs(b *testing.B) {
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(&discardWriter{}, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	if err != nil {
+		b.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	h := handler.WithAttrs([]slog.Attr{slog.String("service", "checkout")}).WithGroup("request")
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "benchmark message", 0)
+	r.AddAttrs(slog.String("method", "GET"), slog.Int("status", 200), slog.Duration("latency", time.Millisecond))
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := h.Handle(ctx, r); err != nil {
+			b.Fatalf("failed to handle record: %s", err.Error())
+		}
+	}
+}
+
+func BenchmarkGoogleCloudLoggingHandlerTextPayload(b *testing.B) {
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(&discardWriter{}, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		LogName:     "slogx-test",
+		PayloadType: slogxgooglecloudlogging.PayloadTypeText,
+		ProjectID:   "slogx-test-project",
+	})
+	if err != nil {
+		b.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "benchmark message", 0)
+	r.AddAttrs(slog.String("attr", "value"), slog.Int("count", 100))
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := handler.Handle(ctx, r); err != nil {
+			b.Fatalf("failed to handle record: %s", err.Error())
+		}
+	}
+}
+
+func BenchmarkGoogleCloudLoggingHandlerDryRun(b *testing.B) {
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandler(slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		DryRun:       true,
+		DryRunWriter: io.Discard,
+		LogName:      "slogx-test",
+		ProjectID:    "slogx-test-project",
+	})
+	if err != nil {
+		b.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "benchmark message", 0)
+	r.AddAttrs(slog.String("attr", "value"), slog.Int("count", 100))
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := handler.Handle(ctx, r); err != nil {
+			b.Fatalf("failed to handle record: %s", err.Error())
+		}
+	}
+}
+
+// benchmarkGoogleCloudLoggingHandlerLevel benchmarks calling Handle() directly for info records against a handler
+// with the given level.
+func benchmarkGoogleCloudLoggingHandlerLevel(b *testing.B, level slog.Level) {
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(&discardWriter{}, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		Level:     level,
+		LogName:   "slogx-test",
+		ProjectID: "slogx-test-project",
+	})
+	if err != nil {
+		b.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "benchmark message", 0)
+	r.AddAttrs(slog.String("attr", "value"), slog.Int("count", 100))
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := handler.Handle(ctx, r); err != nil {
+			b.Fatalf("failed to handle record: %s", err.Error())
+		}
+	}
+}
+
+// decodePayload decodes the JSON payload of the given entry.
+func decodePayload(t *testing.T, entry logging.Entry) map[string]any {
+	t.Helper()
+	raw, ok := entry.Payload.(json.RawMessage)
+	if !ok {
+		t.Fatalf("expected a JSON payload, got %T", entry.Payload)
+	}
+	payload := map[string]any{}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		t.Fatalf("failed to decode payload %q: %s", string(raw), err.Error())
+	}
+	return payload
+}
+
+// labelsValuer is a slog.LogValuer which resolves to a group of labels.
+type labelsValuer map[string]string
+
+func (v labelsValuer) LogValue() slog.Value {
+	attrs := []slog.Attr{}
+	for k, label := range v {
+		attrs = append(attrs, slog.String(k, label))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// userValuer is a slog.LogValuer which resolves to a group containing a sensitive value.
+type userValuer struct {
+	name     string
+	password string
+}
+
+func (v userValuer) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("name", v.name), slog.String("password", v.password))
+}
+
+// correlationIDKey is the context key under which tests of the CorrelationIDExtractor option store the ID.
+type correlationIDKey struct{}
+
+// operationIDKey is the context key under which tests of the OperationExtractor option store the operation's ID.
+type operationIDKey struct{}
+
+// spanContextKey marks a context as containing a span in tests of the DeriveTraceFromOTel option.
+type spanContextKey struct{}
+
+// traceKey stores the trace and span IDs returned by the TraceExtractor in tests of that option.
+type traceKey struct{}
+
+// newTestHandler creates a handler which records the entries written to it in memory, failing the test if it cannot
+// be created.
+func newTestHandler(t *testing.T,
+	opts slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions) (*slogxgooglecloudlogging.GoogleCloudLoggingHandler,
+	*memoryWriter) {
+	t.Helper()
+	w := &memoryWriter{}
+	handler, err := slogxgooglecloudlogging.NewGoogleCloudLoggingHandlerWithWriter(w, opts)
+	if err != nil {
+		t.Fatalf("failed to create Google Cloud Logging Handler: %s", err.Error())
+	}
+	return handler, w
+}
+
+// startFakeServer serves the given fake Google Cloud Logging API on a local port until the test finishes, returning
+// its address.
+func startFakeServer(t *testing.T, fake *fakeLoggingServer) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+	server := grpc.NewServer()
+	loggingpb.RegisterLoggingServiceV2Server(server, fake)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+// fakeLoggingServer is a Google Cloud Logging API server which records the entries written to it.
+//
+// If err is set, every write fails with it instead.
+type fakeLoggingServer struct {
+	loggingpb.UnimplementedLoggingServiceV2Server
+	entries []*loggingpb.LogEntry
+	err     error
+	lock    sync.Mutex
+}
+
+func (s *fakeLoggingServer) Messages() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	messages := []string{}
+	for _, e := range s.entries {
+		if payload := e.GetJsonPayload(); payload != nil {
+			messages = append(messages, payload.GetFields()["message"].GetStringValue())
+		}
+	}
+	return messages
+}
+
+func (s *fakeLoggingServer) WriteLogEntries(_ context.Context,
+	req *loggingpb.WriteLogEntriesRequest) (*loggingpb.WriteLogEntriesResponse, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	for _, e := range req.GetEntries() {
+		if e.LogName == "" {
+			e.LogName = req.GetLogName()
+		}
+		if e.Resource == nil {
+			e.Resource = req.GetResource()
+		}
+		s.entries = append(s.entries, e)
+	}
+	return &loggingpb.WriteLogEntriesResponse{}, nil
+}
+
+func (s *fakeLoggingServer) Resources() []*monitoredres.MonitoredResource {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	resources := []*monitoredres.MonitoredResource{}
+	for _, e := range s.entries {
+		if !strings.HasSuffix(e.GetLogName(), "/logs/diagnostic-log") {
+			resources = append(resources, e.GetResource())
+		}
+	}
+	return resources
+}
+
+func (s *fakeLoggingServer) LogNames() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names := []string{}
+	for _, e := range s.entries {
+		// the client writes a diagnostic entry with the first entry written by the process
+		if !strings.HasSuffix(e.GetLogName(), "/logs/diagnostic-log") {
+			names = append(names, e.GetLogName())
+		}
+	}
+	return names
+}
+
+func (s *fakeLoggingServer) Traces() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	traces := []string{}
+	for _, e := range s.entries {
+		if !strings.HasSuffix(e.GetLogName(), "/logs/diagnostic-log") {
+			traces = append(traces, e.GetTrace())
+		}
+	}
+	return traces
+}
+
+// memoryWriter is an entry writer which records entries in memory rather than sending them to Google Cloud Logging.
+type memoryWriter struct {
+	entries []logging.Entry
+	flushes int
+	lock    sync.Mutex
+	syncs   int
+}
+
+func (w *memoryWriter) Entries() []logging.Entry {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]logging.Entry{}, w.entries...)
+}
+
+func (w *memoryWriter) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.flushes++
+	return nil
+}
+
+func (w *memoryWriter) Flushes() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.flushes
+}
+
+func (w *memoryWriter) Log(e logging.Entry) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.entries = append(w.entries, e)
+}
+
+func (w *memoryWriter) LogSync(_ context.Context, e logging.Entry) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.entries = append(w.entries, e)
+	w.syncs++
+	return nil
+}
+
+func (w *memoryWriter) Syncs() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.syncs
+}
+
+var _ slogxgooglecloudlogging.EntryWriter = &memoryWriter{}
+
+// errWriting is the error returned by failingWriter.
+var errWriting = errors.New("writing failed")
+
+// failingWriter is an entry writer which fails to write every entry.
+type failingWriter struct{}
+
+func (w *failingWriter) Flush() error {
+	return nil
+}
+
+func (w *failingWriter) Log(logging.Entry) {}
+
+func (w *failingWriter) LogSync(context.Context, logging.Entry) error {
+	return errWriting
+}
+
+// blockingWriter is an entry writer which blocks until released or, for synchronous writes, until the context is done.
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Flush() error {
+	<-w.release
+	return nil
+}
+
+func (w *blockingWriter) Log(logging.Entry) {}
+
+func (w *blockingWriter) LogSync(ctx context.Context, _ logging.Entry) error {
+	select {
+	case <-w.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// discardWriter is an entry writer which discards all entries.
+type discardWriter struct{}
+
+func (w *discardWriter) Flush() error {
+	return nil
+}
+
+func (w *discardWriter) Log(logging.Entry) {}
+
+func (w *discardWriter) LogSync(context.Context, logging.Entry) error {
+	return nil
+}
+
+var _ slogxgooglecloudlogging.EntryWriter = &discardWriter{}
+
+// capturingFormatter is a formatter which records the attributes it is given before formatting them as JSON.
+type capturingFormatter struct {
+	attrs []slog.Attr
+}
+
+func (f *capturingFormatter) FormatRecord(ctx context.Context, t time.Time, l slogx.Level, pc uintptr, msg string,
+	attrs []slog.Attr) (*slogx.Buffer, error) {
+	f.attrs = attrs
+	return formatter.DefaultJSONFormatter().FormatRecord(ctx, t, l, pc, msg, attrs)
+}
+
+// checkedFormatter is a formatter which signals each time Handle checks whether or not it reads the handler's
+// options, which happens once Handle has checked that the handler has not been shut down.
+type checkedFormatter struct {
+	checked chan struct{}
+}
+
+func (f *checkedFormatter) FormatRecord(ctx context.Context, t time.Time, l slogx.Level, pc uintptr, msg string,
+	attrs []slog.Attr) (*slogx.Buffer, error) {
+	return formatter.DefaultJSONFormatter().FormatRecord(ctx, t, l, pc, msg, attrs)
+}
+
+func (f *checkedFormatter) UsesHandlerOptions() bool {
+	f.checked <- struct{}{}
+	return false
+}
+
+var _ slogxgooglecloudlogging.OptionsFormatter = &checkedFormatter{}
+
+// panicFormatter is a formatter that always panics.
+type panicFormatter struct{}
+
+func (f *panicFormatter) FormatRecord(_ context.Context, _ time.Time, _ slogx.Level, _ uintptr, _ string,
+	_ []slog.Attr) (*slogx.Buffer, error) {
+	panic("formatter exploded")
+}
+
+// errFormatting is the error returned by errFormatter.
+var errFormatting = errors.New("formatting failed")
+
+// errFormatter is a formatter that always fails so that no records are ever sent to Google Cloud Logging.
+type errFormatter struct{}
+
+func (f *errFormatter) FormatRecord(_ context.Context, _ time.Time, _ slogx.Level, _ uintptr, _ string,
+	_ []slog.Attr) (*slogx.Buffer, error) {
+	return nil, errFormatting
+}
+
+var _ formatter.BufferFormatter = &errFormatter{}
+
+// garbageFormatter is a formatter that always produces an invalid JSON payload.
+type garbageFormatter struct{}
+
+func (f *garbageFormatter) FormatRecord(_ context.Context, _ time.Time, _ slogx.Level, _ uintptr, _ string,
+	_ []slog.Attr) (*slogx.Buffer, error) {
+	buf := &slogx.Buffer{}
+	buf.WriteString("{not json")
+	return buf, nil
+}
+
+var _ formatter.BufferFormatter = &garbageFormatter{}
+
+// optionsFormatter is a formatter which records the handler options found in the context.
+type optionsFormatter struct {
+	options *slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions
+	uses    bool
+}
+
+func (f *optionsFormatter) FormatRecord(ctx context.Context, t time.Time, l slogx.Level, pc uintptr, msg string,
+	attrs []slog.Attr) (*slogx.Buffer, error) {
+	f.options = slogxgooglecloudlogging.GetGoogleCloudLoggingHandlerOptionsFromContext(ctx)
+	return formatter.DefaultJSONFormatter().FormatRecord(ctx, t, l, pc, msg, attrs)
+}
+
+func (f *optionsFormatter) UsesHandlerOptions() bool {
+	return f.uses
+}
+
+var _ slogxgooglecloudlogging.OptionsFormatter = &optionsFormatter{}
+
+// shuffledFormatter is a formatter which writes the top-level attributes and the attributes of groups in map iteration
+// order, so the field order of its payloads differs between records.
+type shuffledFormatter struct{}
+
+func (f *shuffledFormatter) FormatRecord(_ context.Context, _ time.Time, _ slogx.Level, _ uintptr, msg string,
+	attrs []slog.Attr) (*slogx.Buffer, error) {
+	buf := &slogx.Buffer{}
+	buf.WriteString(shuffledJSON(append([]slog.Attr{slog.String("msg", msg)}, attrs...)))
+	return buf, nil
+}
+
+// shuffledJSON encodes the given attributes as a JSON object with its fields in map iteration order.
+func shuffledJSON(attrs []slog.Attr) string {
+	fields := map[string]string{}
+	for _, a := range attrs {
+		if a.Value.Kind() == slog.KindGroup {
+			fields[a.Key] = shuffledJSON(a.Value.Group())
+			continue
+		}
+		value, _ := json.Marshal(a.Value.Any())
+		fields[a.Key] = string(value)
+	}
+	parts := []string{}
+	for k, v := range fields {
+		parts = append(parts, fmt.Sprintf("%q:%s", k, v))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// staticFormatter is a formatter that always produces the same payload.
+type staticFormatter struct {
+	payload string
+}
+
+func (f *staticFormatter) FormatRecord(_ context.Context, _ time.Time, _ slogx.Level, _ uintptr, _ string,
+	_ []slog.Attr) (*slogx.Buffer, error) {
+	buf := &slogx.Buffer{}
+	buf.WriteString(f.payload)
+	return buf, nil
+}
+
+var _ formatter.BufferFormatter = &staticFormatter{}
+
 type User struct {
 	Username  string    `json:"username"`
 	Password  string    `json:"password"`
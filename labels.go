@@ -0,0 +1,126 @@
+package slogxgooglecloudlogging
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// contextLabelsKey is the context key under which labels added using WithLabels are stored.
+type contextLabelsKey struct{}
+
+// WithLabels returns a copy of the given context which carries the given labels in addition to any labels already
+// added to the context.
+//
+// The handler attaches the labels carried by the context passed to Handle to the entry. Labels given here replace
+// any existing context labels with the same key.
+func WithLabels(ctx context.Context, labels map[string]string) context.Context {
+	existing, _ := ctx.Value(contextLabelsKey{}).(map[string]string)
+	merged := make(map[string]string, len(existing)+len(labels))
+	for k, v := range existing {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, contextLabelsKey{}, merged)
+}
+
+// labels returns the labels to attach to the entry for the given record.
+//
+// From lowest to highest precedence, labels are taken from the static Labels, the context using WithLabels, the special
+// "logging.googleapis.com/labels" attribute, attributes promoted using the LabelGroupName and LabelAttrPrefix options,
+// the correlation ID label, the group path label, the function name label and finally the LabelExtractor. If there are
+// no labels to attach, nil is returned.
+func (h *GoogleCloudLoggingHandler) labels(ctx context.Context, r slog.Record, attrs []slog.Attr,
+	attrLabels map[string]string) map[string]string {
+	labels := map[string]string{}
+	for k, v := range h.options.Labels {
+		labels[k] = v
+	}
+	if contextLabels, ok := ctx.Value(contextLabelsKey{}).(map[string]string); ok {
+		for k, v := range contextLabels {
+			labels[k] = v
+		}
+	}
+	for k, v := range attrLabels {
+		labels[k] = v
+	}
+	if h.options.GroupPathLabelKey != "" && len(h.groups) > 0 {
+		labels[h.options.GroupPathLabelKey] = strings.Join(h.groups, ".")
+	}
+	if h.options.FunctionNameLabelKey != "" && r.PC != 0 {
+		if function := callerFrame(r.PC).Function; function != "" {
+			labels[h.options.FunctionNameLabelKey] = function
+		}
+	}
+	if h.options.LabelExtractor != nil {
+		for k, v := range h.options.LabelExtractor(ctx, r, attrs) {
+			labels[k] = v
+		}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+// mergeLabels returns the given labels combined into a single map, with labels from later maps replacing those with
+// the same key from earlier maps.
+func mergeLabels(labels ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, l := range labels {
+		for k, v := range l {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// promoteLabelAttrs removes the top-level group named by the LabelGroupName option and any top-level attributes whose
+// key starts with the LabelAttrPrefix option from the given attributes and returns them as labels.
+//
+// Attributes within the label group are keyed by their own key, with the keys of any nested groups joined using ".",
+// while prefixed attributes are keyed by the remainder of the attribute key. If no attributes are promoted, the given
+// attributes are returned as-is along with a nil map.
+func (h *GoogleCloudLoggingHandler) promoteLabelAttrs(attrs []slog.Attr) ([]slog.Attr, map[string]string) {
+	prefix := h.options.LabelAttrPrefix
+	var labels map[string]string
+	var remaining []slog.Attr
+	for i, attr := range attrs {
+		isGroup := attr.Key == h.options.LabelGroupName && attr.Value.Kind() == slog.KindGroup
+		key, ok := strings.CutPrefix(attr.Key, prefix)
+		if !isGroup && (prefix == "" || !ok || key == "") {
+			if remaining != nil {
+				remaining = append(remaining, attr)
+			}
+			continue
+		}
+		if labels == nil {
+			labels = map[string]string{}
+			remaining = append(make([]slog.Attr, 0, len(attrs)), attrs[:i]...)
+		}
+		if isGroup {
+			addGroupLabels(labels, "", attr.Value.Group())
+		} else {
+			labels[key] = attr.Value.Resolve().String()
+		}
+	}
+	if labels == nil {
+		return attrs, nil
+	}
+	return remaining, labels
+}
+
+// addGroupLabels adds the given attributes of a label group to the given labels, prefixing their keys with the given
+// prefix.
+func addGroupLabels(labels map[string]string, prefix string, attrs []slog.Attr) {
+	for _, attr := range attrs {
+		value := attr.Value.Resolve()
+		if value.Kind() == slog.KindGroup {
+			addGroupLabels(labels, prefix+attr.Key+".", value.Group())
+			continue
+		}
+		labels[prefix+attr.Key] = value.String()
+	}
+}
@@ -0,0 +1,128 @@
+package slogxgooglecloudlogging
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"cloud.google.com/go/logging"
+)
+
+// sharedClient holds the Google Cloud Logging client shared by a handler and every handler cloned from it.
+//
+// When the LazyInit option is set, the client is created the first time it is needed rather than when the handler is
+// created. Creation is only attempted once, so an error creating the client is returned for every later use.
+type sharedClient struct {
+	client    *logging.Client
+	err       error
+	lazy      bool
+	newClient func() (*logging.Client, error)
+	once      sync.Once
+}
+
+// newSharedClient creates a new object holding the given client, which has already been created.
+func newSharedClient(client *logging.Client) *sharedClient {
+	c := &sharedClient{client: client}
+	c.once.Do(func() {})
+	return c
+}
+
+// newLazyClient creates a new object which creates its client using the given function the first time it is needed.
+func newLazyClient(newClient func() (*logging.Client, error)) *sharedClient {
+	return &sharedClient{
+		lazy:      true,
+		newClient: newClient,
+	}
+}
+
+// close closes the client if it has been created, ensuring it is never created afterwards.
+func (c *sharedClient) close() error {
+	c.once.Do(func() { c.err = ErrHandlerShutdown })
+	if c.client == nil {
+		return nil
+	}
+	return c.client.Close()
+}
+
+// get returns the client, creating it if necessary.
+func (c *sharedClient) get() (*logging.Client, error) {
+	c.once.Do(func() { c.client, c.err = c.newClient() })
+	return c.client, c.err
+}
+
+// logger returns a writer for the given log using the client and the given options.
+//
+// If the client is created lazily, the logger is created the first time an entry is written to it.
+func (c *sharedClient) logger(logName string, opts []logging.LoggerOption, onError func(error)) EntryWriter {
+	if !c.lazy {
+		return c.client.Logger(logName, opts...)
+	}
+	return &lazyLogger{
+		client:  c,
+		logName: logName,
+		onError: onError,
+		options: opts,
+	}
+}
+
+// lazyLogger is an entry writer which creates the client and logger it writes through the first time an entry is
+// written.
+//
+// If the client cannot be created, the error is returned by LogSync and passed to the onError function by Log.
+type lazyLogger struct {
+	client  *sharedClient
+	created atomic.Bool
+	err     error
+	logger  *logging.Logger
+	logName string
+	onError func(error)
+	once    sync.Once
+	options []logging.LoggerOption
+}
+
+var _ EntryWriter = (*lazyLogger)(nil)
+
+// Flush sends any buffered entries.
+//
+// Nothing is done if no entries have been written, so flushing never creates the client.
+func (l *lazyLogger) Flush() error {
+	if !l.created.Load() {
+		return nil
+	}
+	return l.logger.Flush()
+}
+
+// Log buffers the entry to be sent in the background.
+func (l *lazyLogger) Log(e logging.Entry) {
+	logger, err := l.get()
+	if err != nil {
+		if l.onError != nil {
+			l.onError(err)
+		}
+		return
+	}
+	logger.Log(e)
+}
+
+// LogSync sends the entry immediately.
+func (l *lazyLogger) LogSync(ctx context.Context, e logging.Entry) error {
+	logger, err := l.get()
+	if err != nil {
+		return err
+	}
+	return logger.LogSync(ctx, e)
+}
+
+// get returns the logger, creating it and the client if necessary.
+func (l *lazyLogger) get() (*logging.Logger, error) {
+	l.once.Do(func() {
+		client, err := l.client.get()
+		if err != nil {
+			l.err = err
+			return
+		}
+		l.logger = client.Logger(l.logName, l.options...)
+		l.created.Store(true)
+	})
+	return l.logger, l.err
+}
@@ -0,0 +1,63 @@
+package slogxgooglecloudlogging
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// lifecycle tracks whether or not a handler has been shut down along with the client it writes through.
+//
+// A single lifecycle is shared by a handler and every handler derived from it using WithAttrs or WithGroup, so
+// shutting down any one of them shuts down all of them. Handlers created using Clone get their own lifecycle but share
+// the client's reference count, so the client is only closed once every clone has been shut down.
+type lifecycle struct {
+	client     *sharedClient
+	clientRefs *atomic.Int32
+	closed     atomic.Bool
+	lock       sync.Mutex
+}
+
+// newLifecycle creates a new lifecycle for the given client.
+//
+// If clientRefs is nil, the client is owned by the caller and is never closed.
+func newLifecycle(client *sharedClient, clientRefs *atomic.Int32) *lifecycle {
+	return &lifecycle{
+		client:     client,
+		clientRefs: clientRefs,
+	}
+}
+
+// isClosed determines whether or not the lifecycle has been shut down.
+func (l *lifecycle) isClosed() bool {
+	return l.closed.Load()
+}
+
+// closeClient releases the lifecycle's reference to the client, closing the client if the handler created it and no
+// other handlers are still sharing it.
+func (l *lifecycle) closeClient() error {
+	if l.client == nil || l.clientRefs == nil {
+		return nil
+	}
+	if l.clientRefs.Add(-1) > 0 {
+		return nil
+	}
+	return l.client.close()
+}
+
+// retain adds a reference to the client for a new handler which will share it.
+//
+// Once the last reference has been released the client is closed, so false is returned rather than reviving it.
+func (l *lifecycle) retain() bool {
+	if l.clientRefs == nil {
+		return true
+	}
+	for {
+		refs := l.clientRefs.Load()
+		if refs <= 0 {
+			return false
+		}
+		if l.clientRefs.CompareAndSwap(refs, refs+1) {
+			return true
+		}
+	}
+}
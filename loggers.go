@@ -0,0 +1,83 @@
+package slogxgooglecloudlogging
+
+import (
+	"errors"
+	"sync"
+
+	"cloud.google.com/go/logging"
+)
+
+// loggerCache creates loggers for log names on demand and reuses them for later entries.
+//
+// It is safe for concurrent use.
+type loggerCache struct {
+	client  *sharedClient
+	lock    sync.RWMutex
+	loggers map[string]EntryWriter
+	onError func(error)
+	options []logging.LoggerOption
+}
+
+// newLoggerCache creates a new cache which creates loggers from the given client using the given options.
+//
+// The given default logger is cached under the given log name so it is reused rather than created again. If the
+// client is created lazily, errors creating it are passed to the onError function when entries are buffered.
+func newLoggerCache(client *sharedClient, opts []logging.LoggerOption, onError func(error), logName string,
+	logger EntryWriter) *loggerCache {
+	return &loggerCache{
+		client:  client,
+		loggers: map[string]EntryWriter{logName: logger},
+		onError: onError,
+		options: opts,
+	}
+}
+
+// clear releases all of the cached loggers.
+func (c *loggerCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	clear(c.loggers)
+}
+
+// flush flushes every cached logger other than the given one, combining any errors encountered into a single error.
+//
+// If continueOnError is false, the first error encountered is returned immediately.
+func (c *loggerCache) flush(skip EntryWriter, continueOnError bool) error {
+	c.lock.RLock()
+	loggers := make([]EntryWriter, 0, len(c.loggers))
+	for _, logger := range c.loggers {
+		if logger != skip {
+			loggers = append(loggers, logger)
+		}
+	}
+	c.lock.RUnlock()
+
+	errs := []error{}
+	for _, logger := range loggers {
+		if err := logger.Flush(); err != nil {
+			if !continueOnError {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// get returns the logger for the given log name, creating it if necessary.
+func (c *loggerCache) get(name string) EntryWriter {
+	c.lock.RLock()
+	logger, ok := c.loggers[name]
+	c.lock.RUnlock()
+	if ok {
+		return logger
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if logger, ok = c.loggers[name]; !ok {
+		logger = c.client.logger(name, c.options, c.onError)
+		c.loggers[name] = logger
+	}
+	return logger
+}
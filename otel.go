@@ -0,0 +1,23 @@
+//go:build otel
+
+package slogxgooglecloudlogging
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func init() {
+	otelSpanContext = spanContextFromOTel
+}
+
+// spanContextFromOTel returns the trace ID, span ID and sampling decision of the OpenTelemetry span in the given
+// context, or empty values if the context does not contain a valid span.
+func spanContextFromOTel(ctx context.Context) (traceID, spanID string, sampled bool) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return "", "", false
+	}
+	return sc.TraceID().String(), sc.SpanID().String(), sc.IsSampled()
+}
@@ -0,0 +1,51 @@
+//go:build otel
+
+package slogxgooglecloudlogging_test
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	slogxgooglecloudlogging "go.innotegrity.dev/slogx-googlecloudlogging"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestGoogleCloudLoggingHandlerOTelSpanContext(t *testing.T) {
+	handler, writer := newTestHandler(t, slogxgooglecloudlogging.GoogleCloudLoggingHandlerOptions{
+		DeriveTraceFromOTel: true,
+		LogName:             "slogx-test",
+		ProjectID:           "slogx-test-project",
+	})
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sampled := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	unsampled := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+
+	logger := slog.New(handler)
+	logger.InfoContext(sampled, "sampled span")
+	logger.InfoContext(unsampled, "unsampled span")
+	logger.Info("without span")
+
+	entries := writer.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for i, sampled := range []bool{true, false} {
+		if entries[i].Trace != "projects/slogx-test-project/traces/4bf92f3577b34da6a3ce929d0e0e4736" ||
+			entries[i].SpanID != "00f067aa0ba902b7" || entries[i].TraceSampled != sampled {
+			t.Errorf("expected trace to be taken from the span with sampled %t, got: %q %q %t", sampled,
+				entries[i].Trace, entries[i].SpanID, entries[i].TraceSampled)
+		}
+	}
+	if entries[2].Trace != "" || entries[2].SpanID != "" {
+		t.Errorf("expected no trace without a span, got: %q %q", entries[2].Trace, entries[2].SpanID)
+	}
+}
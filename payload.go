@@ -0,0 +1,439 @@
+package slogxgooglecloudlogging
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"cloud.google.com/go/logging"
+)
+
+// maxPooledPayloadBytes is the capacity above which payload buffers are not returned to payloadBufferPool, so a
+// single large payload does not keep a large buffer alive.
+const maxPooledPayloadBytes = 64 << 10
+
+// payloadBufferPool holds the buffers used to encode processed JSON payloads so they can be reused across records.
+var payloadBufferPool = sync.Pool{
+	New: func() any {
+		return new([]byte)
+	},
+}
+
+// payloadField is a single top-level field within a JSON object payload.
+type payloadField struct {
+	key   string
+	value json.RawMessage
+}
+
+// payloadObject is a JSON object payload whose top-level fields are kept in their original order.
+type payloadObject []payloadField
+
+// parsePayloadObject parses the given payload into its top-level fields.
+//
+// The values of the fields refer to the given payload rather than copying it. If the payload is not a JSON object,
+// false is returned.
+func parsePayloadObject(payload []byte) (payloadObject, bool) {
+	if !json.Valid(payload) {
+		return nil, false
+	}
+	i := skipJSONSpace(payload, 0)
+	if payload[i] != '{' {
+		return nil, false
+	}
+	obj := payloadObject{}
+	if i = skipJSONSpace(payload, i+1); payload[i] == '}' {
+		return obj, true
+	}
+	for {
+		end := scanJSONString(payload, i)
+		key := string(payload[i+1 : end-1])
+		if bytes.IndexByte(payload[i:end], '\\') >= 0 {
+			if err := json.Unmarshal(payload[i:end], &key); err != nil {
+				return nil, false
+			}
+		}
+		i = skipJSONSpace(payload, skipJSONSpace(payload, end)+1)
+		end = scanJSONValue(payload, i)
+		obj = append(obj, payloadField{key: key, value: json.RawMessage(payload[i:end:end])})
+		if i = skipJSONSpace(payload, end); payload[i] != ',' {
+			return obj, true
+		}
+		i = skipJSONSpace(payload, i+1)
+	}
+}
+
+// skipJSONSpace returns the index of the first non-whitespace character in the given JSON at or after index i.
+func skipJSONSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\r' || data[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// scanJSONString returns the index just past the end of the JSON string starting at index i of the given valid JSON.
+func scanJSONString(data []byte, i int) int {
+	for i++; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return i
+}
+
+// scanJSONValue returns the index just past the end of the JSON value starting at index i of the given valid JSON.
+func scanJSONValue(data []byte, i int) int {
+	switch data[i] {
+	case '"':
+		return scanJSONString(data, i)
+	case '{', '[':
+		depth := 0
+		for ; i < len(data); i++ {
+			switch data[i] {
+			case '"':
+				i = scanJSONString(data, i) - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth--; depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return i
+	}
+	for i < len(data) && data[i] != ',' && data[i] != '}' && data[i] != ']' && data[i] != ' ' && data[i] != '\t' &&
+		data[i] != '\r' && data[i] != '\n' {
+		i++
+	}
+	return i
+}
+
+// index returns the index of the field with the given key or -1 if the key is not present.
+func (o payloadObject) index(key string) int {
+	for i, f := range o {
+		if f.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// get returns the raw value of the field with the given key.
+func (o payloadObject) get(key string) (json.RawMessage, bool) {
+	if i := o.index(key); i >= 0 {
+		return o[i].value, true
+	}
+	return nil, false
+}
+
+// set replaces the value of the field with the given key, adding the field to the start of the object if it does
+// not already exist.
+//
+// The object may contain the key more than once when the DisableAttrDeduplication option is set, in which case the
+// first field is replaced and the others are removed so the payload is left with a single value for the key.
+func (o payloadObject) set(key string, value any) (payloadObject, error) {
+	raw, err := marshalPayloadValue(value)
+	if err != nil {
+		return o, err
+	}
+	if i := o.index(key); i >= 0 {
+		o[i].value = raw
+		return append(o[:i+1], o[i+1:].remove(key)...), nil
+	}
+	return append(payloadObject{{key: key, value: raw}}, o...), nil
+}
+
+// remove removes every field with the given key from the object.
+func (o payloadObject) remove(key string) payloadObject {
+	return slices.DeleteFunc(o, func(f payloadField) bool {
+		return f.key == key
+	})
+}
+
+// bytes encodes the object back into a JSON payload.
+func (o payloadObject) bytes() []byte {
+	return o.appendTo(nil)
+}
+
+// appendTo encodes the object as a JSON payload, appending it to the given buffer.
+func (o payloadObject) appendTo(buf []byte) []byte {
+	size := 2
+	for _, f := range o {
+		size += len(f.key) + len(f.value) + 4
+	}
+	buf = slices.Grow(buf, size)
+	buf = append(buf, '{')
+	for i, f := range o {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONString(buf, f.key)
+		buf = append(buf, ':')
+		buf = append(buf, f.value...)
+	}
+	return append(buf, '}')
+}
+
+// appendJSONString appends the given string to dst as a JSON string without escaping HTML characters.
+//
+// Strings made up entirely of printable ASCII characters which do not need escaping are appended directly, avoiding
+// the allocations made by the encoding/json package.
+func appendJSONString(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c > 0x7e || c == '"' || c == '\\' {
+			raw, _ := encodePayloadValue(s)
+			return append(dst, raw...)
+		}
+	}
+	dst = append(dst, '"')
+	dst = append(dst, s...)
+	return append(dst, '"')
+}
+
+// marshalPayloadValue encodes the given value as JSON without escaping HTML characters.
+func marshalPayloadValue(value any) (json.RawMessage, error) {
+	switch v := value.(type) {
+	case json.RawMessage:
+		return v, nil
+	case string:
+		return appendJSONString(make([]byte, 0, len(v)+2), v), nil
+	}
+	return encodePayloadValue(value)
+}
+
+// encodePayloadValue encodes the given value using the encoding/json package without escaping HTML characters.
+func encodePayloadValue(value any) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// unescapeHTML replaces the \u003c, \u003e and \u0026 escape sequences produced by the encoding/json package
+// for the HTML characters <, > and & with the characters themselves.
+//
+// Escaped backslashes are skipped over so that a literal "\\u003c" within a string value is left unchanged.
+func unescapeHTML(payload []byte) []byte {
+	if !bytes.Contains(payload, []byte(`\u00`)) {
+		return payload
+	}
+	result := make([]byte, 0, len(payload))
+	for i := 0; i < len(payload); i++ {
+		c := payload[i]
+		if c != '\\' || i+1 >= len(payload) {
+			result = append(result, c)
+			continue
+		}
+		if payload[i+1] == 'u' && i+5 < len(payload) {
+			switch string(bytes.ToLower(payload[i+2 : i+6])) {
+			case "003c":
+				result = append(result, '<')
+				i += 5
+				continue
+			case "003e":
+				result = append(result, '>')
+				i += 5
+				continue
+			case "0026":
+				result = append(result, '&')
+				i += 5
+				continue
+			}
+		}
+		result = append(result, c, payload[i+1])
+		i++
+	}
+	return result
+}
+
+// severityFromPayload returns the severity named by the top-level string field with the given key in the payload.
+//
+// Severity names are matched case-insensitively using logging.ParseSeverity. If the payload is not a JSON object or
+// the field is missing, is not a string or does not name a known severity, false is returned.
+func severityFromPayload(payload []byte, key string) (logging.Severity, bool) {
+	obj, ok := parsePayloadObject(payload)
+	if !ok {
+		return logging.Default, false
+	}
+	raw, ok := obj.get(key)
+	if !ok {
+		return logging.Default, false
+	}
+	var name string
+	if err := json.Unmarshal(raw, &name); err != nil {
+		return logging.Default, false
+	}
+	severity := logging.ParseSeverity(name)
+	if severity == logging.Default && !strings.EqualFold(name, logging.Default.String()) {
+		return logging.Default, false
+	}
+	return severity, true
+}
+
+// truncatedSuffix is appended to string values which have been shortened to fit within the maximum payload size.
+const truncatedSuffix = "...(truncated)"
+
+// truncate shortens the largest string fields of the object, including those within nested objects, until its
+// encoded size is no more than maxBytes, adding a "truncated" field to the object to mark that it has been changed.
+//
+// If the object cannot be made small enough by shortening its string fields, false is returned.
+func (o payloadObject) truncate(maxBytes int) (payloadObject, bool) {
+	o, err := o.set("truncated", true)
+	if err != nil {
+		return o, false
+	}
+	return o.shorten(maxBytes)
+}
+
+// shorten shortens the largest string fields of the object until its encoded size is no more than maxBytes.
+//
+// Nested objects are shortened in the same way, so their own largest string fields are shortened first. Strings
+// within arrays are left as-is. If the object cannot be made small enough, it is returned shortened as much as
+// possible along with false.
+func (o payloadObject) shorten(maxBytes int) (payloadObject, bool) {
+	exhausted := map[int]bool{}
+	for {
+		size := len(o.bytes())
+		if size <= maxBytes {
+			return o, true
+		}
+
+		// find the largest string or object field which can still be shortened
+		largest := -1
+		for i, f := range o {
+			if exhausted[i] || len(f.value) == 0 || (f.value[0] != '"' && f.value[0] != '{') {
+				continue
+			}
+			if largest < 0 || len(f.value) > len(o[largest].value) {
+				largest = i
+			}
+		}
+		if largest < 0 {
+			return o, false
+		}
+		target := len(o[largest].value) - (size - maxBytes)
+
+		// nested objects are shortened as much as they need to be, or as much as possible, in a single pass
+		if o[largest].value[0] == '{' {
+			exhausted[largest] = true
+			if nested, ok := parsePayloadObject(o[largest].value); ok {
+				nested, _ = nested.shorten(max(target, 0))
+				o[largest].value = nested.bytes()
+			}
+			continue
+		}
+
+		// removing n decoded bytes removes at least n encoded bytes, so this is enough to fit unless the whole value
+		// has to be removed
+		var value string
+		if err := json.Unmarshal(o[largest].value, &value); err != nil {
+			exhausted[largest] = true
+			continue
+		}
+		keep := len(value) - (size - maxBytes) - len(truncatedSuffix)
+		if keep <= 0 {
+			keep = 0
+			exhausted[largest] = true
+		}
+		truncated := truncateString(value, keep) + truncatedSuffix
+		if len(truncated) >= len(value) {
+			exhausted[largest] = true
+			continue
+		}
+		var err error
+		if o[largest].value, err = marshalPayloadValue(truncated); err != nil {
+			return o, false
+		}
+	}
+}
+
+// truncateString shortens the string to at most n bytes without splitting a multi-byte character.
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// dedupeMessage removes any fields other than the top-level field with key keep which are named using one of the
+// given keys and contain the given message, including fields within nested objects.
+//
+// Fields with one of the given keys but a different value are left as-is since they are not copies of the message.
+func (o payloadObject) dedupeMessage(keep string, keys []string, message string) payloadObject {
+	deduped := o[:0:0]
+	for _, f := range o {
+		if f.key != keep && slices.Contains(keys, f.key) && isJSONString(f.value, message) {
+			continue
+		}
+		if nested, ok := parsePayloadObject(f.value); ok {
+			if d := nested.dedupeMessage("", keys, message); len(d) != len(nested) {
+				f.value = d.bytes()
+			}
+		}
+		deduped = append(deduped, f)
+	}
+	return deduped
+}
+
+// isJSONString determines whether or not the given raw JSON value is a string equal to s.
+func isJSONString(raw json.RawMessage, s string) bool {
+	var value string
+	if len(raw) == 0 || raw[0] != '"' || json.Unmarshal(raw, &value) != nil {
+		return false
+	}
+	return value == s
+}
+
+// sortKeys sorts the fields of the object by key, along with the fields of any objects nested within it.
+func (o payloadObject) sortKeys() payloadObject {
+	for i := range o {
+		o[i].value = sortJSONKeys(o[i].value)
+	}
+	slices.SortStableFunc(o, func(a, b payloadField) int {
+		return strings.Compare(a.key, b.key)
+	})
+	return o
+}
+
+// sortJSONKeys returns the given valid JSON value with the fields of any objects within it sorted by key.
+//
+// Values other than objects and arrays are returned as-is.
+func sortJSONKeys(value json.RawMessage) json.RawMessage {
+	if len(value) == 0 {
+		return value
+	}
+	switch value[0] {
+	case '{':
+		if obj, ok := parsePayloadObject(value); ok {
+			return obj.sortKeys().bytes()
+		}
+	case '[':
+		sorted := make([]byte, 0, len(value))
+		sorted = append(sorted, '[')
+		i := skipJSONSpace(value, 1)
+		for i < len(value) && value[i] != ']' {
+			end := scanJSONValue(value, i)
+			if len(sorted) > 1 {
+				sorted = append(sorted, ',')
+			}
+			sorted = append(sorted, sortJSONKeys(value[i:end:end])...)
+			if i = skipJSONSpace(value, end); i < len(value) && value[i] == ',' {
+				i = skipJSONSpace(value, i+1)
+			}
+		}
+		return append(sorted, ']')
+	}
+	return value
+}
@@ -0,0 +1,108 @@
+package slogxgooglecloudlogging
+
+import (
+	"errors"
+	"sync"
+
+	"cloud.google.com/go/logging"
+)
+
+// projectCache creates clients for projects chosen by the ProjectRouter option on demand and reuses them for later
+// entries.
+//
+// Each project has its own cache of loggers, so the LogNameFunc option is applied within the chosen project. It is
+// safe for concurrent use.
+type projectCache struct {
+	clients   []*logging.Client
+	closed    bool
+	lock      sync.RWMutex
+	logName   string
+	newClient func(projectID string) (*logging.Client, error)
+	options   []logging.LoggerOption
+	projects  map[string]*loggerCache
+}
+
+// newProjectCache creates a new cache which creates clients using the given function and loggers for the given log
+// name using the given options.
+func newProjectCache(newClient func(projectID string) (*logging.Client, error), opts []logging.LoggerOption,
+	logName string) *projectCache {
+	return &projectCache{
+		clients:   []*logging.Client{},
+		logName:   logName,
+		newClient: newClient,
+		options:   opts,
+		projects:  map[string]*loggerCache{},
+	}
+}
+
+// close releases all of the cached loggers and closes every client created by the cache, combining any errors
+// encountered into a single error.
+func (c *projectCache) close() error {
+	c.lock.Lock()
+	clients := c.clients
+	c.clients = []*logging.Client{}
+	c.closed = true
+	clear(c.projects)
+	c.lock.Unlock()
+
+	errs := []error{}
+	for _, client := range clients {
+		if err := client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// flush flushes every logger created for every project, combining any errors encountered into a single error.
+//
+// If continueOnError is false, the first error encountered is returned immediately.
+func (c *projectCache) flush(continueOnError bool) error {
+	c.lock.RLock()
+	projects := make([]*loggerCache, 0, len(c.projects))
+	for _, loggers := range c.projects {
+		projects = append(projects, loggers)
+	}
+	c.lock.RUnlock()
+
+	errs := []error{}
+	for _, loggers := range projects {
+		if err := loggers.flush(nil, continueOnError); err != nil {
+			if !continueOnError {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// get returns the logger for the given log name in the given project, creating the project's client if necessary.
+//
+// If the client cannot be created, the error is returned and creating it is attempted again for the next entry.
+// ErrHandlerShutdown is returned if the cache has been closed.
+func (c *projectCache) get(projectID, name string) (EntryWriter, error) {
+	c.lock.RLock()
+	loggers, ok := c.projects[projectID]
+	c.lock.RUnlock()
+	if ok {
+		return loggers.get(name), nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return nil, ErrHandlerShutdown
+	}
+	if loggers, ok = c.projects[projectID]; !ok {
+		client, err := c.newClient(projectID)
+		if err != nil {
+			return nil, err
+		}
+		logger := client.Logger(c.logName, c.options...)
+		loggers = newLoggerCache(newSharedClient(client), c.options, nil, c.logName, logger)
+		c.clients = append(c.clients, client)
+		c.projects[projectID] = loggers
+	}
+	return loggers.get(name), nil
+}
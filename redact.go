@@ -0,0 +1,58 @@
+package slogxgooglecloudlogging
+
+import (
+	"log/slog"
+	"slices"
+)
+
+// RedactedValue is the value which replaces the values of attributes redacted using the RedactKeys option.
+const RedactedValue = "[REDACTED]"
+
+// redactAttrs applies the RedactKeys and RedactFunc options to the given attributes.
+//
+// The given slice is returned as-is if neither option is set.
+func (h *GoogleCloudLoggingHandler) redactAttrs(attrs []slog.Attr) []slog.Attr {
+	if len(h.options.RedactKeys) == 0 && h.options.RedactFunc == nil {
+		return attrs
+	}
+	attrs, _ = redactAttrs(attrs, "", h.options.RedactKeys, h.options.RedactFunc)
+	return attrs
+}
+
+// redactAttrs replaces the values of any of the given attributes whose full path, prefixed by the given prefix, is
+// one of the given keys with RedactedValue and passes every other attribute outside of a group to the given function.
+//
+// Attributes and groups are copied rather than modified in place, since they may be shared with the handler. The
+// given slice is returned as-is along with false if no attributes were changed.
+func redactAttrs(attrs []slog.Attr, prefix string, keys []string,
+	redact func(key string, value slog.Value) (slog.Value, bool)) ([]slog.Attr, bool) {
+	var result []slog.Attr
+	for i, attr := range attrs {
+		path := prefix + attr.Key
+		value := attr.Value.Resolve()
+		keep, changed := true, false
+		switch {
+		case slices.Contains(keys, path):
+			value, changed = slog.StringValue(RedactedValue), true
+		case value.Kind() == slog.KindGroup:
+			var group []slog.Attr
+			if group, changed = redactAttrs(value.Group(), path+".", keys, redact); changed {
+				value, keep = slog.GroupValue(group...), len(group) > 0
+			}
+		case redact != nil:
+			value, keep = redact(path, value)
+			changed = true
+		}
+		if changed && result == nil {
+			result = make([]slog.Attr, i, len(attrs))
+			copy(result, attrs[:i])
+		}
+		if result != nil && keep {
+			result = append(result, slog.Attr{Key: attr.Key, Value: value})
+		}
+	}
+	if result == nil {
+		return attrs, false
+	}
+	return result, true
+}
@@ -0,0 +1,97 @@
+package slogxgooglecloudlogging
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy holds the options for retrying writes to Google Cloud Logging which fail due to a transient error.
+//
+// Only errors with a gRPC status code of Unavailable, DeadlineExceeded or ResourceExhausted are retried. All other
+// errors are returned immediately.
+type RetryPolicy struct {
+	// InitialBackoff is the amount of time to wait before the first retry.
+	InitialBackoff time.Duration
+
+	// MaxAttempts is the maximum number of times to attempt the write, including the first attempt.
+	//
+	// A value less than 1 is treated as 1.
+	MaxAttempts int
+
+	// MaxBackoff is the maximum amount of time to wait between attempts.
+	//
+	// A value of 0 means the backoff is not capped.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor by which the backoff is increased after each attempt.
+	//
+	// A value less than 1 is treated as 1.
+	Multiplier float64
+}
+
+// DefaultRetryPolicy returns a default retry policy.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxAttempts:    5,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+	}
+}
+
+// isRetryableError determines whether or not the given error is a transient error which should be retried.
+func isRetryableError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// retry calls the given function until it succeeds, it returns a non-retryable error, the maximum number of
+// attempts is reached or the context is done.
+//
+// The last error returned by the function is returned if it never succeeds.
+func (p RetryPolicy) retry(ctx context.Context, fn func(context.Context) error) error {
+	attempts := p.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	backoff := p.InitialBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(ctx); err == nil || attempt >= attempts || !isRetryableError(err) {
+			return err
+		}
+
+		// wait for a jittered backoff period before trying again, giving up if the context is done first
+		wait := backoff
+		if wait > 0 {
+			wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		backoff = time.Duration(float64(backoff) * multiplier)
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package slogxgooglecloudlogging
+
+import (
+	"context"
+
+	"cloud.google.com/go/logging"
+)
+
+// contextMinSeverityKey is the context key under which the minimum severity added using WithMinSeverity is stored.
+type contextMinSeverityKey struct{}
+
+// contextSeverityThresholdKey is the context key under which the threshold added using WithSeverityThreshold is
+// stored.
+type contextSeverityThresholdKey struct{}
+
+// WithMinSeverity returns a copy of the given context which carries the given minimum severity.
+//
+// The handler raises the severity of entries for records logged with the context to at least the given severity,
+// which is useful for escalating all of the logs for a single request, such as during a debugging session, without
+// changing the handler's level. The escalated severity is what is compared against the MinSeverity option, so
+// escalated entries are not discarded for being below it. Records below the handler's Level are still discarded.
+func WithMinSeverity(ctx context.Context, severity logging.Severity) context.Context {
+	return context.WithValue(ctx, contextMinSeverityKey{}, severity)
+}
+
+// WithSeverityThreshold returns a copy of the given context which carries the given severity threshold.
+//
+// Unlike WithMinSeverity, which raises the severity of entries, the handler discards entries for records logged with
+// the context whose severity is below the threshold. This is useful for quieting a noisy code path without changing
+// the handler's level. The threshold applies in addition to the MinSeverity option and is compared against the
+// severity after any escalation using WithMinSeverity.
+func WithSeverityThreshold(ctx context.Context, severity logging.Severity) context.Context {
+	return context.WithValue(ctx, contextSeverityThresholdKey{}, severity)
+}
+
+// escalateSeverity returns the given severity raised to the minimum severity carried by the context, if any.
+func escalateSeverity(ctx context.Context, severity logging.Severity) logging.Severity {
+	if floor, ok := ctx.Value(contextMinSeverityKey{}).(logging.Severity); ok && severity < floor {
+		return floor
+	}
+	return severity
+}
+
+// belowSeverityThreshold determines whether or not the given severity is below the threshold carried by the context,
+// if any.
+func belowSeverityThreshold(ctx context.Context, severity logging.Severity) bool {
+	threshold, ok := ctx.Value(contextSeverityThresholdKey{}).(logging.Severity)
+	return ok && severity < threshold
+}
@@ -0,0 +1,87 @@
+package slogxgooglecloudlogging
+
+import (
+	"log/slog"
+	"strings"
+)
+
+const (
+	// specialKeyPrefix is the prefix shared by the special payload keys recognized by Google Cloud Logging.
+	specialKeyPrefix = "logging.googleapis.com/"
+
+	// specialLabelsKey is the special payload key containing labels to attach to the entry.
+	specialLabelsKey = specialKeyPrefix + "labels"
+
+	// specialSpanIDKey is the special payload key containing the span ID of the entry.
+	specialSpanIDKey = specialKeyPrefix + "spanId"
+
+	// specialTraceKey is the special payload key containing the trace of the entry.
+	specialTraceKey = specialKeyPrefix + "trace"
+
+	// specialTraceSampledKey is the special payload key indicating whether or not the entry's trace was sampled.
+	specialTraceSampledKey = specialKeyPrefix + "trace_sampled"
+)
+
+// specialFields holds the entry fields taken from special payload keys found in the record's attributes.
+type specialFields struct {
+	labels       map[string]string
+	spanID       string
+	trace        string
+	traceSampled bool
+}
+
+// extractSpecialFields removes any top-level attributes using the special payload keys recognized by Google Cloud
+// Logging from the given attributes and returns their values so they can be moved into the corresponding entry
+// fields.
+//
+// If no special keys are present, the given attributes are returned as-is.
+func extractSpecialFields(attrs []slog.Attr) ([]slog.Attr, specialFields) {
+	var fields specialFields
+	found := false
+	for _, attr := range attrs {
+		if strings.HasPrefix(attr.Key, specialKeyPrefix) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return attrs, fields
+	}
+
+	remaining := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		value := attr.Value.Resolve()
+		switch attr.Key {
+		case specialLabelsKey:
+			fields.labels = specialLabels(value)
+		case specialSpanIDKey:
+			fields.spanID = value.String()
+		case specialTraceKey:
+			fields.trace = value.String()
+		case specialTraceSampledKey:
+			fields.traceSampled = value.Kind() == slog.KindBool && value.Bool()
+		default:
+			remaining = append(remaining, attr)
+		}
+	}
+	return remaining, fields
+}
+
+// specialLabels converts the value of the special labels key, which may either be a group or a map of strings,
+// into labels.
+func specialLabels(value slog.Value) map[string]string {
+	labels := map[string]string{}
+	switch value.Kind() {
+	case slog.KindGroup:
+		for _, attr := range value.Group() {
+			labels[attr.Key] = attr.Value.Resolve().String()
+		}
+	case slog.KindAny:
+		if m, ok := value.Any().(map[string]string); ok {
+			for k, v := range m {
+				labels[k] = v
+			}
+		}
+	}
+	return labels
+}
@@ -0,0 +1,88 @@
+package slogxgooglecloudlogging
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// otelSpanContext returns the trace ID, span ID and sampling decision of the OpenTelemetry span in the given context.
+//
+// It is only set when the package is built using the "otel" build tag.
+var otelSpanContext func(ctx context.Context) (traceID, spanID string, sampled bool)
+
+// TraceFromHeader parses the value of an X-Cloud-Trace-Context header, which has the format
+// "TRACE_ID/SPAN_ID;o=OPTIONS", into the trace, span ID and sampling decision expected by Google Cloud Logging.
+//
+// The trace is returned as "projects/PROJECT_ID/traces/TRACE_ID" unless projectID is empty, in which case the bare
+// trace ID is returned so the handler can qualify it with its own project ID. The decimal span ID from the header is
+// converted to the 16 character hexadecimal form used by Cloud Trace. The trace is only marked as sampled if the
+// options contain "o=1".
+//
+// If the trace ID is missing or malformed, empty values are returned. If only the span ID is missing or malformed,
+// the trace and sampling decision are still returned with an empty span ID.
+func TraceFromHeader(header string, projectID string) (trace, spanID string, sampled bool) {
+	value, options, _ := strings.Cut(strings.TrimSpace(header), ";")
+	traceID, span, _ := strings.Cut(value, "/")
+	if !isHexID(traceID, 32) {
+		return "", "", false
+	}
+	if id, err := strconv.ParseUint(span, 10, 64); err == nil && id != 0 {
+		spanID = fmt.Sprintf("%016x", id)
+	}
+	return qualifyTrace(strings.ToLower(traceID), projectID), spanID, options == "o=1"
+}
+
+// TraceFromTraceparent parses the value of a W3C traceparent header, which has the format
+// "VERSION-TRACE_ID-PARENT_ID-FLAGS", into the trace, span ID and sampling decision expected by Google Cloud Logging.
+//
+// The trace is returned as "projects/PROJECT_ID/traces/TRACE_ID" unless projectID is empty, in which case the bare
+// trace ID is returned so the handler can qualify it with its own project ID. The trace is marked as sampled if the
+// sampled bit of the flags is set.
+//
+// Version "00" values must contain exactly four fields. Values using later versions may contain additional fields,
+// which are ignored, while the invalid version "ff" is rejected. If the value is malformed, empty values are returned.
+func TraceFromTraceparent(traceparent, projectID string) (trace, spanID string, sampled bool) {
+	fields := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(fields) < 4 {
+		return "", "", false
+	}
+	version, traceID, parentID, flags := fields[0], fields[1], fields[2], fields[3]
+	if !isHex(version, 2) || strings.EqualFold(version, "ff") || (version == "00" && len(fields) != 4) {
+		return "", "", false
+	}
+	if !isHexID(traceID, 32) || !isHexID(parentID, 16) || !isHex(flags, 2) {
+		return "", "", false
+	}
+	v, _ := strconv.ParseUint(flags, 16, 8)
+	return qualifyTrace(strings.ToLower(traceID), projectID), strings.ToLower(parentID), v&0x01 == 0x01
+}
+
+// isHex determines whether or not the given value consists of exactly length hexadecimal characters.
+func isHex(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+	for _, c := range value {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// isHexID determines whether or not the given ID consists of exactly length hexadecimal characters which are not all
+// zeros.
+func isHexID(id string, length int) bool {
+	return isHex(id, length) && strings.Trim(id, "0") != ""
+}
+
+// qualifyTrace returns the full resource name of the given trace ID in the given project, or the bare trace ID if
+// projectID is empty.
+func qualifyTrace(traceID, projectID string) string {
+	if projectID == "" {
+		return traceID
+	}
+	return fmt.Sprintf("projects/%s/traces/%s", projectID, traceID)
+}